@@ -24,23 +24,114 @@ void _sqlite3_result_blob(sqlite3_context* ctx, const void* b, int l);
 import "C"
 
 import (
+	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"math"
 	"reflect"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
+// RepanicCallbacks makes panics in Go callbacks called by SQLite, such as
+// SQL functions, aggregators and virtual tables, crash the process instead
+// of being reported as errors by the statement that made the call. It
+// exists to debug those callbacks and should be set before opening
+// connections.
+var RepanicCallbacks = false
+
+// CallbackPanicStack adds the stack trace of the panicking goroutine to the
+// errors reported for panics in Go callbacks.
+var CallbackPanicStack = false
+
+// callbackPanicError converts a panic recovered in a Go callback into the
+// error to report to SQLite.
+func callbackPanicError(r interface{}) error {
+	if RepanicCallbacks {
+		panic(r)
+	}
+	msg := fmt.Sprintf("panic in Go callback: %v", r)
+	if CallbackPanicStack {
+		msg += "\n" + string(debug.Stack())
+	}
+	return errors.New(msg)
+}
+
+// recoverCallback must be deferred by trampolines of SQL functions. Panics
+// must not unwind through the C frames of SQLite, so it recovers them and
+// makes them the error of the function.
+func recoverCallback(ctx *C.sqlite3_context) {
+	if r := recover(); r != nil {
+		callbackError(ctx, callbackPanicError(r))
+	}
+}
+
+// recoverDestroy must be deferred by callbacks that can't report errors.
+func recoverDestroy() {
+	if r := recover(); r != nil {
+		callbackPanicError(r)
+	}
+}
+
 //export callbackTrampoline
 func callbackTrampoline(ctx *C.sqlite3_context, argc int, argv **C.sqlite3_value) {
+	defer recoverCallback(ctx)
 	args := (*[(math.MaxInt32 - 1) / unsafe.Sizeof((*C.sqlite3_value)(nil))]*C.sqlite3_value)(unsafe.Pointer(argv))[:argc:argc]
 	fi := lookupHandle(uintptr(C.sqlite3_user_data(ctx))).(*functionInfo)
 	fi.Call(ctx, args)
 }
 
+//export rawCallbackTrampoline
+func rawCallbackTrampoline(ctx *C.sqlite3_context, argc int, argv **C.sqlite3_value) {
+	defer recoverCallback(ctx)
+	var args []SQLiteValue
+	if argc > 0 {
+		args = (*[(math.MaxInt32 - 1) / unsafe.Sizeof(SQLiteValue{})]SQLiteValue)(unsafe.Pointer(argv))[:argc:argc]
+	}
+	f := lookupHandle(uintptr(C.sqlite3_user_data(ctx))).(RawFunc)
+	f((*SQLiteContext)(ctx), args)
+}
+
+//export rtreeQueryTrampoline
+func rtreeQueryTrampoline(info *C.sqlite3_rtree_query_info) (rc C.int) {
+	defer func() {
+		// The errors of R*Tree queries have no message.
+		if r := recover(); r != nil {
+			callbackPanicError(r)
+			rc = C.SQLITE_ERROR
+		}
+	}()
+	f := lookupHandle(uintptr(info.pContext)).(RTreeQueryFunc)
+	return f.call(info)
+}
+
+//export auxDataDestroy
+func auxDataDestroy(p unsafe.Pointer) {
+	defer recoverDestroy()
+	deleteHandle(uintptr(p))
+}
+
+// destroyFunction is called by SQLite when a Go function is replaced or
+// unregistered, or when its connection is closed.
+//
+//export destroyFunction
+func destroyFunction(p unsafe.Pointer) {
+	defer recoverDestroy()
+	handle := uintptr(p)
+	if r, ok := handleVals.Load(handle); ok {
+		val := r.(handleVal)
+		val.db.removeFunction(val.val)
+	}
+	deleteHandle(handle)
+}
+
 //export stepTrampoline
 func stepTrampoline(ctx *C.sqlite3_context, argc C.int, argv **C.sqlite3_value) {
+	defer recoverCallback(ctx)
 	args := (*[(math.MaxInt32 - 1) / unsafe.Sizeof((*C.sqlite3_value)(nil))]*C.sqlite3_value)(unsafe.Pointer(argv))[:int(argc):int(argc)]
 	ai := lookupHandle(uintptr(C.sqlite3_user_data(ctx))).(*aggInfo)
 	ai.Step(ctx, args)
@@ -48,6 +139,7 @@ func stepTrampoline(ctx *C.sqlite3_context, argc C.int, argv **C.sqlite3_value)
 
 //export doneTrampoline
 func doneTrampoline(ctx *C.sqlite3_context) {
+	defer recoverCallback(ctx)
 	handle := uintptr(C.sqlite3_user_data(ctx))
 	ai := lookupHandle(handle).(*aggInfo)
 	ai.Done(ctx)
@@ -60,41 +152,68 @@ type handleVal struct {
 	val interface{}
 }
 
-var handleLock sync.Mutex
-var handleVals = make(map[uintptr]handleVal)
+// The handles are looked up on every callback from SQLite, from any number
+// of connections at once, but only added when registering functions, modules
+// and cursors. sync.Map serves such lookups without taking a lock. Each
+// connection also keeps the set of its handles, so that closing it doesn't
+// need to walk the handles of the others.
+var handleVals sync.Map // of uintptr to handleVal
 var handleIndex uintptr = 100
 
+// conns maps the sqlite3 handles to their connection, for the callbacks that
+// only get the former, such as SQLiteContext.Conn.
+var conns sync.Map // of *C.sqlite3 to *SQLiteConn
+
 func newHandle(db *SQLiteConn, v interface{}) uintptr {
-	handleLock.Lock()
-	defer handleLock.Unlock()
-	i := handleIndex
-	handleIndex++
-	handleVals[i] = handleVal{db, v}
+	i := atomic.AddUintptr(&handleIndex, 1) - 1
+	handleVals.Store(i, handleVal{db, v})
+	if db != nil {
+		db.handlesMu.Lock()
+		if db.handles == nil {
+			db.handles = make(map[uintptr]struct{})
+		}
+		db.handles[i] = struct{}{}
+		db.handlesMu.Unlock()
+	}
 	return i
 }
 
 func lookupHandle(handle uintptr) interface{} {
-	handleLock.Lock()
-	defer handleLock.Unlock()
-	r, ok := handleVals[handle]
+	return lookupHandleVal(handle).val
+}
+
+func lookupHandleVal(handle uintptr) handleVal {
+	r, ok := handleVals.Load(handle)
 	if !ok {
-		if handle >= 100 && handle < handleIndex {
+		if handle >= 100 && handle < atomic.LoadUintptr(&handleIndex) {
 			panic("deleted handle")
 		} else {
 			panic("invalid handle")
 		}
 	}
-	return r.val
+	return r.(handleVal)
+}
+
+func deleteHandle(handle uintptr) {
+	r, ok := handleVals.Load(handle)
+	if !ok {
+		return
+	}
+	handleVals.Delete(handle)
+	if db := r.(handleVal).db; db != nil {
+		db.handlesMu.Lock()
+		delete(db.handles, handle)
+		db.handlesMu.Unlock()
+	}
 }
 
 func deleteHandles(db *SQLiteConn) {
-	handleLock.Lock()
-	defer handleLock.Unlock()
-	for handle, val := range handleVals {
-		if val.db == db {
-			delete(handleVals, handle)
-		}
+	db.handlesMu.Lock()
+	defer db.handlesMu.Unlock()
+	for handle := range db.handles {
+		handleVals.Delete(handle)
 	}
+	db.handles = nil
 }
 
 // This is only here so that tests can refer to it.
@@ -184,6 +303,9 @@ func callbackArgGeneric(v *C.sqlite3_value) (reflect.Value, error) {
 	case C.SQLITE_BLOB:
 		return callbackArgBytes(v)
 	case C.SQLITE_NULL:
+		if p, ok := valuePointer(v); ok {
+			return reflect.ValueOf(p), nil
+		}
 		// Interpret NULL as a nil byte slice.
 		var ret []byte
 		return reflect.ValueOf(ret), nil
@@ -192,8 +314,114 @@ func callbackArgGeneric(v *C.sqlite3_value) (reflect.Value, error) {
 	}
 }
 
+// callbackArgValue converts the argument to the driver.Value rows would
+// hold for it.
+func callbackArgValue(v *C.sqlite3_value) (driver.Value, error) {
+	if C.sqlite3_value_type(v) == C.SQLITE_NULL {
+		return nil, nil
+	}
+	val, err := callbackArgGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+	return val.Interface(), nil
+}
+
+func callbackArgTime(v *C.sqlite3_value) (reflect.Value, error) {
+	val, err := callbackArgValue(v)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	t, err := valueTime(val)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return reflect.ValueOf(t), nil
+}
+
+// valueTime converts an INTEGER unix timestamp, in seconds or milliseconds,
+// or a TEXT time in one of the SQLiteTimestampFormats to a time.Time.
+func valueTime(val interface{}) (time.Time, error) {
+	switch v := val.(type) {
+	case int64:
+		return unixTime(v), nil
+	case string:
+		return parseTime(v)
+	default:
+		return time.Time{}, fmt.Errorf("argument must be an INTEGER or TEXT time")
+	}
+}
+
+// callbackArgPointer converts arguments to the Pointer they hold, or to a
+// Pointer to nil.
+func callbackArgPointer(v *C.sqlite3_value) (reflect.Value, error) {
+	p, _ := valuePointer(v)
+	return reflect.ValueOf(p), nil
+}
+
+// callbackArgScanner converts arguments with the Scan method of a type that
+// implements sql.Scanner, such as sql.NullInt64.
+type callbackArgScanner struct {
+	typ reflect.Type
+}
+
+func (c callbackArgScanner) Run(v *C.sqlite3_value) (reflect.Value, error) {
+	val, err := callbackArgValue(v)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	p := reflect.New(c.typ)
+	if err := p.Interface().(sql.Scanner).Scan(val); err != nil {
+		return reflect.Value{}, err
+	}
+	return p.Elem(), nil
+}
+
+// callbackArgPtr converts NULL to a nil pointer, and other arguments to a
+// pointer to a value converted by f.
+type callbackArgPtr struct {
+	f   callbackArgConverter
+	typ reflect.Type
+}
+
+func (c callbackArgPtr) Run(v *C.sqlite3_value) (reflect.Value, error) {
+	if C.sqlite3_value_type(v) == C.SQLITE_NULL {
+		return reflect.Zero(c.typ), nil
+	}
+	val, err := c.f(v)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	p := reflect.New(c.typ.Elem())
+	p.Elem().Set(val)
+	return p, nil
+}
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	valuerType  = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+)
+
 func callbackArg(typ reflect.Type) (callbackArgConverter, error) {
+	if reflect.PtrTo(typ).Implements(scannerType) {
+		c := callbackArgScanner{typ}
+		return c.Run, nil
+	}
+	if typ == timeType {
+		return callbackArgTime, nil
+	}
+	if typ == pointerType {
+		return callbackArgPointer, nil
+	}
 	switch typ.Kind() {
+	case reflect.Ptr:
+		f, err := callbackArg(typ.Elem())
+		if err != nil {
+			return nil, err
+		}
+		c := callbackArgPtr{f, typ}
+		return c.Run, nil
 	case reflect.Interface:
 		if typ.NumMethod() != 0 {
 			return nil, errors.New("the only supported interface type is interface{}")
@@ -307,8 +535,86 @@ func callbackRetText(ctx *C.sqlite3_context, v reflect.Value) error {
 	return nil
 }
 
+func callbackRetTime(ctx *C.sqlite3_context, v reflect.Value) error {
+	t, ok := v.Interface().(time.Time)
+	if !ok {
+		return fmt.Errorf("cannot convert %s to TEXT", v.Type())
+	}
+	C._sqlite3_result_text(ctx, C.CString(t.Format(SQLiteTimestampFormats[0])))
+	return nil
+}
+
+// callbackRetValue sets the result to a value of any supported type, or to
+// NULL if it is nil.
+func callbackRetValue(ctx *C.sqlite3_context, val interface{}) error {
+	if val == nil {
+		C.sqlite3_result_null(ctx)
+		return nil
+	}
+	conv, err := callbackRet(reflect.TypeOf(val))
+	if err != nil {
+		return err
+	}
+	return conv(ctx, reflect.ValueOf(val))
+}
+
+func callbackRetGeneric(ctx *C.sqlite3_context, v reflect.Value) error {
+	if v.IsNil() {
+		C.sqlite3_result_null(ctx)
+		return nil
+	}
+	return callbackRetValue(ctx, v.Elem().Interface())
+}
+
+func callbackRetValuer(ctx *C.sqlite3_context, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		C.sqlite3_result_null(ctx)
+		return nil
+	}
+	val, err := v.Interface().(driver.Valuer).Value()
+	if err != nil {
+		return err
+	}
+	return callbackRetValue(ctx, val)
+}
+
+// callbackRetPtr converts a nil pointer to NULL, and other pointers by
+// converting the value they point to with f.
+type callbackRetPtr struct {
+	f callbackRetConverter
+}
+
+func (c callbackRetPtr) Run(ctx *C.sqlite3_context, v reflect.Value) error {
+	if v.IsNil() {
+		C.sqlite3_result_null(ctx)
+		return nil
+	}
+	return c.f(ctx, v.Elem())
+}
+
 func callbackRet(typ reflect.Type) (callbackRetConverter, error) {
+	if typ.Implements(valuerType) {
+		return callbackRetValuer, nil
+	}
+	if typ == timeType {
+		return callbackRetTime, nil
+	}
+	if typ == pointerType {
+		return callbackRetPointer, nil
+	}
 	switch typ.Kind() {
+	case reflect.Interface:
+		if typ.NumMethod() != 0 {
+			return nil, errors.New("the only supported interface type is interface{}")
+		}
+		return callbackRetGeneric, nil
+	case reflect.Ptr:
+		f, err := callbackRet(typ.Elem())
+		if err != nil {
+			return nil, err
+		}
+		c := callbackRetPtr{f}
+		return c.Run, nil
 	case reflect.Slice:
 		if typ.Elem().Kind() != reflect.Uint8 {
 			return nil, errors.New("the only supported slice type is []byte")
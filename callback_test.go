@@ -1,10 +1,12 @@
 package sqlite3
 
 import (
+	"database/sql"
 	"errors"
 	"math"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestCallbackArgCast(t *testing.T) {
@@ -66,6 +68,12 @@ func TestCallbackConverters(t *testing.T) {
 		{uint(0), false},
 		{float64(0), false},
 		{float32(0), false},
+		{time.Time{}, false},
+		{(*int8)(nil), false},
+		{(*string)(nil), false},
+		{sql.NullInt64{}, false},
+		{sql.NullString{}, false},
+		{(*sql.NullFloat64)(nil), false},
 
 		{func() {}, true},
 		{complex64(complex(0, 0)), true},
@@ -73,7 +81,7 @@ func TestCallbackConverters(t *testing.T) {
 		{struct{}{}, true},
 		{map[string]string{}, true},
 		{[]string{}, true},
-		{(*int8)(nil), true},
+		{(*complex64)(nil), true},
 		{make(chan int), true},
 	}
 
@@ -10,6 +10,7 @@ package sqlite3
 #cgo CFLAGS: -DSQLITE_ENABLE_RTREE -DSQLITE_THREADSAFE
 #cgo CFLAGS: -DSQLITE_ENABLE_FTS3 -DSQLITE_ENABLE_FTS3_PARENTHESIS -DSQLITE_ENABLE_FTS4_UNICODE61
 #cgo CFLAGS: -DSQLITE_TRACE_SIZE_LIMIT=15
+#cgo CFLAGS: -DSQLITE_ENABLE_COLUMN_METADATA=1
 #cgo CFLAGS: -DHAVE_USLEEP
 #cgo CFLAGS: -Wno-deprecated-declarations
 #ifndef USE_LIBSQLITE3
@@ -36,6 +37,18 @@ package sqlite3
 # define SQLITE_DETERMINISTIC 0
 #endif
 
+#ifndef SQLITE_DIRECTONLY
+# define SQLITE_DIRECTONLY 0x000080000
+#endif
+
+#ifndef SQLITE_SUBTYPE
+# define SQLITE_SUBTYPE 0x000100000
+#endif
+
+#ifndef SQLITE_INNOCUOUS
+# define SQLITE_INNOCUOUS 0x000200000
+#endif
+
 static int
 _sqlite3_open_v2(const char *filename, sqlite3 **ppDb, int flags, const char *zVfs) {
 #ifdef SQLITE_OPEN_URI
@@ -77,6 +90,8 @@ _sqlite3_step(sqlite3_stmt* stmt, long long* rowid, long long* changes)
   return rv;
 }
 
+int _sqlite3_reset_status(sqlite3_stmt *stmt);
+
 void _sqlite3_result_text(sqlite3_context* ctx, const char* s) {
   sqlite3_result_text(ctx, s, -1, &free);
 }
@@ -86,6 +101,8 @@ void _sqlite3_result_blob(sqlite3_context* ctx, const void* b, int l) {
 }
 
 
+void destroyFunction(void*);
+
 int _sqlite3_create_function(
   sqlite3 *db,
   const char *zFunctionName,
@@ -96,10 +113,12 @@ int _sqlite3_create_function(
   void (*xStep)(sqlite3_context*,int,sqlite3_value**),
   void (*xFinal)(sqlite3_context*)
 ) {
-  return sqlite3_create_function(db, zFunctionName, nArg, eTextRep, (void*) pApp, xFunc, xStep, xFinal);
+  return sqlite3_create_function_v2(db, zFunctionName, nArg, eTextRep, (void*) pApp, xFunc, xStep, xFinal, pApp ? destroyFunction : NULL);
 }
 
 void callbackTrampoline(sqlite3_context*, int, sqlite3_value**);
+void rawCallbackTrampoline(sqlite3_context*, int, sqlite3_value**);
+void auxDataDestroy(void*);
 */
 import "C"
 import (
@@ -113,6 +132,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unsafe"
 
@@ -137,8 +157,32 @@ var SQLiteTimestampFormats = []string{
 	"2006-01-02",
 }
 
+// unixTime converts a unix timestamp to a UTC time. It assumes a millisecond
+// timestamp if it's 13 digits -- too large to be a reasonable timestamp in
+// seconds.
+func unixTime(v int64) time.Time {
+	if v > 1e12 || v < -1e12 {
+		v *= int64(time.Millisecond) // convert ms to nsec
+	} else {
+		v *= int64(time.Second) // convert sec to nsec
+	}
+	return time.Unix(0, v).UTC()
+}
+
+// parseTime parses s with the first of SQLiteTimestampFormats that fits,
+// ignoring a trailing "Z". Times without a zone are in UTC.
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSuffix(s, "Z")
+	for _, format := range SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(format, s, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as a time", s)
+}
+
 func init() {
-	sql.Register("sqlite3", &SQLiteDriver{})
+	sql.Register("sqlite3", &SQLiteDriver{CArray: true})
 }
 
 // Version returns SQLite library version information.
@@ -153,6 +197,22 @@ func Version() (libVersion string, libVersionNumber int, sourceID string) {
 type SQLiteDriver struct {
 	Extensions  []string
 	ConnectHook func(*SQLiteConn) error
+
+	// CArray registers the carray table function on each connection
+	// before ConnectHook runs. It returns the elements of a slice passed
+	// as a Pointer, and replaces the carray extension of SQLite, whose
+	// arguments differ. The "sqlite3" driver sets it.
+	CArray bool
+
+	// StmtCacheSize is the number of closed prepared statements that each
+	// connection keeps for reuse, unless the DSN sets _stmt_cache_size.
+	// Reusing a statement reads the schema version of the main database,
+	// which takes a read lock and may wait for the busy timeout.
+	StmtCacheSize int
+
+	// Limits are the run-time limits set on each connection before
+	// ConnectHook runs, unless the DSN sets them with _limit_XXX.
+	Limits map[Limit]int
 }
 
 // SQLiteConn implement sql.Conn.
@@ -162,6 +222,12 @@ type SQLiteConn struct {
 	txlock      string
 	funcs       []*functionInfo
 	aggregators []*aggInfo
+	cache       *stmtCache
+
+	// handles holds the callback handles owned by the connection, which
+	// are deleted when it is closed.
+	handlesMu sync.Mutex
+	handles   map[uintptr]struct{}
 }
 
 // SQLiteTx implemen sql.Tx.
@@ -176,6 +242,12 @@ type SQLiteStmt struct {
 	t      string
 	closed bool
 	cls    bool
+
+	// cacheKey is the SQL text the statement was prepared from, set when
+	// the statement goes back to the connection's cache on Close. schema
+	// is the schema version the cache had seen when it was prepared.
+	cacheKey string
+	schema   int64
 }
 
 // SQLiteResult implement sql.Result.
@@ -190,12 +262,31 @@ type SQLiteRows struct {
 	nc       int
 	cols     []string
 	decltype []string
+	kinds    []columnKind
+	bufs     [][]byte
 	cls      bool
 	done     chan struct{}
+
+	// status holds the counters of the query once statusDone is set. The
+	// statement counters are reset when the query starts.
+	status     StmtStatus
+	statusDone bool
+
+	// The statements following this one in a multi-statement query, and
+	// the arguments left for them. See NextResultSet.
+	ctx  context.Context
+	tail string
+	args []namedValue
+
+	// cur holds the rows of the current result set once NextResultSet has
+	// moved past the first one. Each set has its own statement and context
+	// watcher, so it is never copied.
+	cur *SQLiteRows
 }
 
 type functionInfo struct {
 	f                 reflect.Value
+	withContext       bool
 	argConverters     []callbackArgConverter
 	variadicConverter callbackArgConverter
 	retConverter      callbackRetConverter
@@ -208,6 +299,10 @@ func (fi *functionInfo) Call(ctx *C.sqlite3_context, argv []*C.sqlite3_value) {
 		return
 	}
 
+	if fi.withContext {
+		args = append([]reflect.Value{reflect.ValueOf((*SQLiteContext)(ctx))}, args...)
+	}
+
 	ret := fi.f.Call(args)
 
 	if len(ret) == 2 && ret[1].Interface() != nil {
@@ -316,50 +411,159 @@ func (tx *SQLiteTx) Rollback() error {
 // RegisterFunc makes a Go function available as a SQLite function.
 //
 // The Go function can have arguments of the following types: any
-// numeric type except complex, bool, []byte, string and
+// numeric type except complex, bool, []byte, string, time.Time and
 // interface{}. interface{} arguments are given the direct translation
 // of the SQLite data type: int64 for INTEGER, float64 for FLOAT,
-// []byte for BLOB, string for TEXT.
+// []byte for BLOB, string for TEXT. time.Time arguments accept TEXT in
+// one of the SQLiteTimestampFormats, or INTEGER unix timestamps.
+//
+// Arguments can also be pointers to the above types, which are nil for
+// NULL, or types such as sql.NullInt64 whose pointer implements
+// sql.Scanner.
 //
 // The function can additionally be variadic, as long as the type of
 // the variadic argument is one of the above.
 //
+// If the first argument of the function is a *SQLiteContext, it receives
+// the context of the call instead of an SQL argument. Through it the
+// function can cache state computed from its arguments with SetAuxData.
+//
+// The same types are supported for the return value. A nil pointer or
+// interface{} returns NULL, an interface{} is otherwise converted
+// according to its dynamic type, and types implementing driver.Valuer are
+// converted using the value they return.
+//
 // If pure is true. SQLite will assume that the function's return
 // value depends only on its inputs, and make more aggressive
 // optimizations in its queries.
 //
 // See _example/go_custom_funcs for a detailed example.
 func (c *SQLiteConn) RegisterFunc(name string, impl interface{}, pure bool) error {
+	return c.RegisterFuncWithOptions(name, impl, FuncOptions{Deterministic: pure})
+}
+
+// FuncOptions are the properties of a function registered with
+// RegisterFuncWithOptions, RegisterRawFuncWithOptions or
+// RegisterAggregatorWithOptions.
+// See: https://www.sqlite.org/c3ref/c_deterministic.html
+type FuncOptions struct {
+	// Deterministic tells SQLite that the function's return value
+	// depends only on its inputs, so that it can make more aggressive
+	// optimizations in its queries. It is the pure argument of
+	// RegisterFunc.
+	Deterministic bool
+
+	// DirectOnly prevents the function from being called from triggers,
+	// views, CHECK constraints and other parts of the schema, which
+	// whoever can write to the database file controls. Requires SQLite
+	// 3.30.0 or later.
+	DirectOnly bool
+
+	// Innocuous tells SQLite that the function has no side effects and
+	// can't leak information, so that it can be called from the schema
+	// even when the schema isn't trusted. Requires SQLite 3.31.0 or later.
+	Innocuous bool
+
+	// Subtype tells SQLite that the function may read the subtypes of its
+	// arguments with SQLiteValue.Subtype. Requires SQLite 3.30.0 or later.
+	Subtype bool
+}
+
+// flags returns the flags to pass to sqlite3_create_function for the
+// options. Older versions of SQLite ignore flags they don't know, so using
+// an option they don't support is an error rather than silently having no
+// effect.
+func (o FuncOptions) flags() (C.int, error) {
+	_, version, _ := Version()
+	opts := C.SQLITE_UTF8
+	if o.Deterministic {
+		opts |= C.SQLITE_DETERMINISTIC
+	}
+	if o.DirectOnly {
+		if version < 3030000 {
+			return 0, errors.New("DirectOnly requires SQLite 3.30.0 or later")
+		}
+		opts |= C.SQLITE_DIRECTONLY
+	}
+	if o.Innocuous {
+		if version < 3031000 {
+			return 0, errors.New("Innocuous requires SQLite 3.31.0 or later")
+		}
+		opts |= C.SQLITE_INNOCUOUS
+	}
+	if o.Subtype {
+		if version < 3030000 {
+			return 0, errors.New("Subtype requires SQLite 3.30.0 or later")
+		}
+		opts |= C.SQLITE_SUBTYPE
+	}
+	return C.int(opts), nil
+}
+
+// RegisterFuncWithOptions is like RegisterFunc, but sets the properties of
+// the function from opts.
+func (c *SQLiteConn) RegisterFuncWithOptions(name string, impl interface{}, opts FuncOptions) error {
+	flags, err := opts.flags()
+	if err != nil {
+		return err
+	}
+
+	fi, numArgs, err := newFunctionInfo(impl)
+	if err != nil {
+		return err
+	}
+
+	// fi must outlast the function in SQLite, or we'll have dangling
+	// pointers. destroyFunction removes it once SQLite lets go of it.
+	c.funcs = append(c.funcs, fi)
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	rv := sqlite3CreateFunction(c.db, cname, C.int(numArgs), flags, newHandle(c, fi), C.callbackTrampoline, nil, nil)
+	if rv != C.SQLITE_OK {
+		return c.lastError()
+	}
+	return nil
+}
+
+// newFunctionInfo prepares the call of a Go function from SQL, and returns
+// the number of arguments to register it with.
+func newFunctionInfo(impl interface{}) (*functionInfo, int, error) {
 	var fi functionInfo
 	fi.f = reflect.ValueOf(impl)
 	t := fi.f.Type()
 	if t.Kind() != reflect.Func {
-		return errors.New("Non-function passed to RegisterFunc")
+		return nil, 0, errors.New("Non-function passed to RegisterFunc")
 	}
 	if t.NumOut() != 1 && t.NumOut() != 2 {
-		return errors.New("SQLite functions must return 1 or 2 values")
+		return nil, 0, errors.New("SQLite functions must return 1 or 2 values")
 	}
 	if t.NumOut() == 2 && !t.Out(1).Implements(reflect.TypeOf((*error)(nil)).Elem()) {
-		return errors.New("Second return value of SQLite function must be error")
+		return nil, 0, errors.New("Second return value of SQLite function must be error")
 	}
 
-	numArgs := t.NumIn()
+	start := 0
+	if t.NumIn() > 0 && t.In(0) == reflect.TypeOf((*SQLiteContext)(nil)) {
+		fi.withContext = true
+		start = 1
+	}
+	numArgs := t.NumIn() - start
 	if t.IsVariadic() {
 		numArgs--
 	}
 
 	for i := 0; i < numArgs; i++ {
-		conv, err := callbackArg(t.In(i))
+		conv, err := callbackArg(t.In(start + i))
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
 		fi.argConverters = append(fi.argConverters, conv)
 	}
 
 	if t.IsVariadic() {
-		conv, err := callbackArg(t.In(numArgs).Elem())
+		conv, err := callbackArg(t.In(start + numArgs).Elem())
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
 		fi.variadicConverter = conv
 		// Pass -1 to sqlite so that it allows any number of
@@ -370,26 +574,98 @@ func (c *SQLiteConn) RegisterFunc(name string, impl interface{}, pure bool) erro
 
 	conv, err := callbackRet(t.Out(0))
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 	fi.retConverter = conv
+	return &fi, numArgs, nil
+}
+
+// RawFunc is the implementation of an SQL function registered with
+// RegisterRawFunc. It reports its result, or its error, through ctx.
+type RawFunc func(ctx *SQLiteContext, args []SQLiteValue)
 
-	// fi must outlast the database connection, or we'll have dangling pointers.
-	c.funcs = append(c.funcs, &fi)
+// RegisterRawFunc makes a Go function available as a SQLite function,
+// without the reflection RegisterFunc relies on to convert arguments and
+// results. This makes it better suited to functions called for every row of
+// large scans.
+//
+// nArg is the number of arguments the function accepts, or -1 for any
+// number. The arguments and the context are only valid during the call.
+//
+// If pure is true. SQLite will assume that the function's return
+// value depends only on its inputs, and make more aggressive
+// optimizations in its queries.
+func (c *SQLiteConn) RegisterRawFunc(name string, nArg int, impl RawFunc, pure bool) error {
+	return c.RegisterRawFuncWithOptions(name, nArg, impl, FuncOptions{Deterministic: pure})
+}
 
+// RegisterRawFuncWithOptions is like RegisterRawFunc, but sets the
+// properties of the function from opts.
+func (c *SQLiteConn) RegisterRawFuncWithOptions(name string, nArg int, impl RawFunc, opts FuncOptions) error {
+	if impl == nil {
+		return errors.New("nil function passed to RegisterRawFunc")
+	}
+	flags, err := opts.flags()
+	if err != nil {
+		return err
+	}
 	cname := C.CString(name)
 	defer C.free(unsafe.Pointer(cname))
-	opts := C.SQLITE_UTF8
-	if pure {
-		opts |= C.SQLITE_DETERMINISTIC
+	rv := sqlite3CreateFunction(c.db, cname, C.int(nArg), flags, newHandle(c, impl), C.rawCallbackTrampoline, nil, nil)
+	if rv != C.SQLITE_OK {
+		return c.lastError()
+	}
+	return nil
+}
+
+// functionTrampoline returns the C callback of the functions whose handle
+// holds a RawFunc when raw is true, or a functionInfo otherwise.
+func functionTrampoline(raw bool) unsafe.Pointer {
+	if raw {
+		return unsafe.Pointer(C.rawCallbackTrampoline)
 	}
-	rv := sqlite3CreateFunction(c.db, cname, C.int(numArgs), C.int(opts), newHandle(c, &fi), C.callbackTrampoline, nil, nil)
+	return unsafe.Pointer(C.callbackTrampoline)
+}
+
+// UnregisterFunc removes the SQL function registered with the given name
+// and number of arguments by RegisterFunc, RegisterRawFunc or their
+// WithOptions variants, and releases its Go implementation. nArg is -1 for
+// variadic functions, and doesn't count a *SQLiteContext argument.
+//
+// Registering a function again under the same name and number of arguments
+// replaces it without the need to unregister it first. Both fail while
+// statements using the function are running.
+func (c *SQLiteConn) UnregisterFunc(name string, nArg int) error {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	rv := sqlite3CreateFunction(c.db, cname, C.int(nArg), C.SQLITE_UTF8, 0, nil, nil, nil)
 	if rv != C.SQLITE_OK {
 		return c.lastError()
 	}
 	return nil
 }
 
+// removeFunction forgets the implementation of a function once SQLite no
+// longer references it.
+func (c *SQLiteConn) removeFunction(v interface{}) {
+	switch v := v.(type) {
+	case *functionInfo:
+		for i, fi := range c.funcs {
+			if fi == v {
+				c.funcs = append(c.funcs[:i], c.funcs[i+1:]...)
+				break
+			}
+		}
+	case *aggInfo:
+		for i, ai := range c.aggregators {
+			if ai == v {
+				c.aggregators = append(c.aggregators[:i], c.aggregators[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
 func sqlite3CreateFunction(db *C.sqlite3, zFunctionName *C.char, nArg C.int, eTextRep C.int, pApp uintptr, xFunc unsafe.Pointer, xStep unsafe.Pointer, xFinal unsafe.Pointer) C.int {
 	return C._sqlite3_create_function(db, zFunctionName, nArg, eTextRep, C.uintptr_t(pApp), (*[0]byte)(unsafe.Pointer(xFunc)), (*[0]byte)(unsafe.Pointer(xStep)), (*[0]byte)(unsafe.Pointer(xFinal)))
 }
@@ -479,6 +755,10 @@ func (c *SQLiteConn) Query(query string, args []driver.Value) (driver.Rows, erro
 	return c.query(context.Background(), query, list)
 }
 
+// query runs the statements of query in order until one returns columns,
+// and returns its rows. The statements before it are run to completion.
+// The remaining statements are left for SQLiteRows.NextResultSet, with the
+// parameters distributed across them in order.
 func (c *SQLiteConn) query(ctx context.Context, query string, args []namedValue) (driver.Rows, error) {
 	start := 0
 	for {
@@ -489,6 +769,7 @@ func (c *SQLiteConn) query(ctx context.Context, query string, args []namedValue)
 		s.(*SQLiteStmt).cls = true
 		na := s.NumInput()
 		if len(args) < na {
+			s.Close()
 			return nil, fmt.Errorf("not enough args to execute query: want %d got %d", na, len(args))
 		}
 		for i := 0; i < na; i++ {
@@ -502,11 +783,21 @@ func (c *SQLiteConn) query(ctx context.Context, query string, args []namedValue)
 		args = args[na:]
 		start += na
 		tail := s.(*SQLiteStmt).t
-		if tail == "" {
+		rc := rows.(*SQLiteRows)
+		if tail == "" || rc.nc > 0 {
+			for i := range args {
+				args[i].Ordinal -= start
+			}
+			rc.ctx = ctx
+			rc.tail = tail
+			rc.args = args
 			return rows, nil
 		}
+		err = rc.skip()
 		rows.Close()
-		s.Close()
+		if err != nil {
+			return nil, err
+		}
 		query = tail
 	}
 }
@@ -543,6 +834,16 @@ func errorString(err Error) string {
 //     "deferred", "exclusive".
 //   _foreign_keys=X
 //     Enable or disable enforcement of foreign keys.  X can be 1 or 0.
+//   _limit_XXX=N
+//     Set the run-time limit XXX to N before ConnectHook runs.  XXX can be
+//     "length", "sql_length", "column", "expr_depth", "compound_select",
+//     "vdbe_op", "function_arg", "attached", "like_pattern_length",
+//     "variable_number", "trigger_depth", "worker_threads".  Overrides the
+//     limit of SQLiteDriver.Limits.
+//   _stmt_cache_size=N
+//     Keep up to N closed prepared statements per connection, keyed by
+//     their SQL text, and reuse them when the same query is prepared again.
+//     Defaults to SQLiteDriver.StmtCacheSize, and 0 disables the cache.
 func (d *SQLiteDriver) Open(dsn string) (driver.Conn, error) {
 	if C.sqlite3_threadsafe() == 0 {
 		return nil, errors.New("sqlite library was not compiled for thread-safe operation")
@@ -552,6 +853,11 @@ func (d *SQLiteDriver) Open(dsn string) (driver.Conn, error) {
 	txlock := "BEGIN"
 	busyTimeout := 5000
 	foreignKeys := -1
+	limits := make(map[Limit]int, len(d.Limits))
+	for id, val := range d.Limits {
+		limits[id] = val
+	}
+	stmtCacheSize := d.StmtCacheSize
 	pos := strings.IndexRune(dsn, '?')
 	if pos >= 1 {
 		params, err := url.ParseQuery(dsn[pos+1:])
@@ -606,6 +912,32 @@ func (d *SQLiteDriver) Open(dsn string) (driver.Conn, error) {
 			}
 		}
 
+		// _limit_XXX
+		for key := range params {
+			if !strings.HasPrefix(key, "_limit_") {
+				continue
+			}
+			id, ok := limitNames[strings.TrimPrefix(key, "_limit_")]
+			if !ok {
+				return nil, fmt.Errorf("Invalid limit: %v", key)
+			}
+			val := params.Get(key)
+			iv, err := strconv.ParseInt(val, 10, 32)
+			if err != nil || iv < 0 {
+				return nil, fmt.Errorf("Invalid %v: %v", key, val)
+			}
+			limits[id] = int(iv)
+		}
+
+		// _stmt_cache_size
+		if val := params.Get("_stmt_cache_size"); val != "" {
+			iv, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || iv < 0 {
+				return nil, fmt.Errorf("Invalid _stmt_cache_size: %v", val)
+			}
+			stmtCacheSize = int(iv)
+		}
+
 		if !strings.HasPrefix(dsn, "file:") {
 			dsn = dsn[:pos]
 		}
@@ -653,7 +985,15 @@ func (d *SQLiteDriver) Open(dsn string) (driver.Conn, error) {
 		}
 	}
 
+	for id, val := range limits {
+		C.sqlite3_limit(db, C.int(id), C.int(val))
+	}
+
 	conn := &SQLiteConn{db: db, loc: loc, txlock: txlock}
+	conns.Store(db, conn)
+	if stmtCacheSize > 0 {
+		conn.cache = newStmtCache(db, stmtCacheSize)
+	}
 
 	if len(d.Extensions) > 0 {
 		if err := conn.loadExtensions(d.Extensions); err != nil {
@@ -662,6 +1002,13 @@ func (d *SQLiteDriver) Open(dsn string) (driver.Conn, error) {
 		}
 	}
 
+	if d.CArray {
+		if err := conn.CreateTableFunc("carray", []string{"value"}, []string{"pointer"}, carray); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
 	if d.ConnectHook != nil {
 		if err := d.ConnectHook(conn); err != nil {
 			conn.Close()
@@ -674,11 +1021,15 @@ func (d *SQLiteDriver) Open(dsn string) (driver.Conn, error) {
 
 // Close the connection.
 func (c *SQLiteConn) Close() error {
+	if c.cache != nil {
+		c.cache.close()
+	}
 	rv := C.sqlite3_close_v2(c.db)
 	if rv != C.SQLITE_OK {
 		return c.lastError()
 	}
 	deleteHandles(c)
+	conns.Delete(c.db)
 	c.db = nil
 	runtime.SetFinalizer(c, nil)
 	return nil
@@ -690,6 +1041,15 @@ func (c *SQLiteConn) Prepare(query string) (driver.Stmt, error) {
 }
 
 func (c *SQLiteConn) prepare(ctx context.Context, query string) (driver.Stmt, error) {
+	var schema int64
+	if c.cache != nil {
+		var e *stmtCacheEntry
+		if e, schema = c.cache.get(query); e != nil {
+			ss := &SQLiteStmt{c: c, s: e.s, t: e.t, cacheKey: query, schema: schema}
+			runtime.SetFinalizer(ss, (*SQLiteStmt).Close)
+			return ss, nil
+		}
+	}
 	pquery := C.CString(query)
 	defer C.free(unsafe.Pointer(pquery))
 	var s *C.sqlite3_stmt
@@ -703,6 +1063,10 @@ func (c *SQLiteConn) prepare(ctx context.Context, query string) (driver.Stmt, er
 		t = strings.TrimSpace(C.GoString(tail))
 	}
 	ss := &SQLiteStmt{c: c, s: s, t: t}
+	if c.cache != nil && s != nil {
+		ss.cacheKey = query
+		ss.schema = schema
+	}
 	runtime.SetFinalizer(ss, (*SQLiteStmt).Close)
 	return ss, nil
 }
@@ -716,6 +1080,12 @@ func (s *SQLiteStmt) Close() error {
 	if s.c == nil || s.c.db == nil {
 		return errors.New("sqlite statement with already closed database connection")
 	}
+	if s.cacheKey != "" && s.c.cache.put(s) {
+		// The statement now belongs to the cache.
+		s.s = nil
+		runtime.SetFinalizer(s, nil)
+		return nil
+	}
 	rv := C.sqlite3_finalize(s.s)
 	if rv != C.SQLITE_OK {
 		return s.c.lastError()
@@ -735,7 +1105,7 @@ type bindArg struct {
 }
 
 func (s *SQLiteStmt) bind(args []namedValue) error {
-	rv := C.sqlite3_reset(s.s)
+	rv := C._sqlite3_reset_status(s.s)
 	if rv != C.SQLITE_ROW && rv != C.SQLITE_OK && rv != C.SQLITE_DONE {
 		return s.c.lastError()
 	}
@@ -780,6 +1150,10 @@ func (s *SQLiteStmt) bind(args []namedValue) error {
 		case time.Time:
 			b := []byte(v.Format(SQLiteTimestampFormats[0]))
 			rv = C._sqlite3_bind_text(s.s, n, (*C.char)(unsafe.Pointer(&b[0])), C.int(len(b)))
+		case Pointer:
+			if err := s.bindPointer(n, v); err != nil {
+				return err
+			}
 		}
 		if rv != C.SQLITE_OK {
 			return s.c.lastError()
@@ -883,12 +1257,14 @@ func (s *SQLiteStmt) exec(ctx context.Context, args []namedValue) (driver.Result
 
 // Close the rows.
 func (rc *SQLiteRows) Close() error {
+	rc = rc.set()
 	if rc.s.closed {
 		return nil
 	}
 	if rc.done != nil {
 		close(rc.done)
 	}
+	rc.finishStatus()
 	if rc.cls {
 		return rc.s.Close()
 	}
@@ -899,8 +1275,73 @@ func (rc *SQLiteRows) Close() error {
 	return nil
 }
 
+// skip runs a statement that returns no columns to completion.
+func (rc *SQLiteRows) skip() error {
+	if rc.s.s == nil {
+		// Nothing but whitespace and comments was left to prepare.
+		return nil
+	}
+	for {
+		rv := C.sqlite3_step(rc.s.s)
+		if rv == C.SQLITE_DONE {
+			return nil
+		}
+		if rv != C.SQLITE_ROW {
+			err := rc.s.c.lastError()
+			C.sqlite3_reset(rc.s.s)
+			return err
+		}
+	}
+}
+
+// set returns the rows of the current result set.
+func (rc *SQLiteRows) set() *SQLiteRows {
+	if rc.cur != nil {
+		return rc.cur
+	}
+	return rc
+}
+
+// HasNextResultSet implement RowsNextResultSet. It reports whether statements
+// are left in the query, even if none of them returns columns.
+func (rc *SQLiteRows) HasNextResultSet() bool {
+	return rc.set().tail != ""
+}
+
+// NextResultSet implement RowsNextResultSet. It closes the current statement
+// and runs the following ones in order until one returns columns, whose
+// rows are then read by Next. Statements without columns are run to
+// completion and skipped. It returns io.EOF when no such statement is left.
+func (rc *SQLiteRows) NextResultSet() error {
+	cur := rc.set()
+	if cur.tail == "" {
+		return io.EOF
+	}
+	c, ctx, tail, args := cur.s.c, cur.ctx, cur.tail, cur.args
+	cur.tail = ""
+	if err := cur.Close(); err != nil {
+		return err
+	}
+	rows, err := c.query(ctx, tail, args)
+	if err != nil {
+		return err
+	}
+	next := rows.(*SQLiteRows)
+	if next.nc == 0 {
+		err = next.skip()
+		next.Close()
+		if err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	rc.cur = next
+	return nil
+}
+
 // Columns return column names.
 func (rc *SQLiteRows) Columns() []string {
+	rc = rc.set()
 	if rc.nc != len(rc.cols) {
 		rc.cols = make([]string, rc.nc)
 		for i := 0; i < rc.nc; i++ {
@@ -912,6 +1353,7 @@ func (rc *SQLiteRows) Columns() []string {
 
 // DeclTypes return column types.
 func (rc *SQLiteRows) DeclTypes() []string {
+	rc = rc.set()
 	if rc.decltype == nil {
 		rc.decltype = make([]string, rc.nc)
 		for i := 0; i < rc.nc; i++ {
@@ -921,13 +1363,51 @@ func (rc *SQLiteRows) DeclTypes() []string {
 	return rc.decltype
 }
 
+// columnKind tells how the values of a column are converted, based on its
+// declared type.
+type columnKind int
+
+const (
+	columnDefault columnKind = iota
+	columnTime
+	columnBool
+)
+
+// columnKinds returns the conversion of each column, computing it on first
+// use.
+func (rc *SQLiteRows) columnKinds() []columnKind {
+	if rc.kinds == nil {
+		rc.kinds = make([]columnKind, rc.nc)
+		for i, t := range rc.DeclTypes() {
+			switch t {
+			case "timestamp", "datetime", "date":
+				rc.kinds[i] = columnTime
+			case "boolean":
+				rc.kinds[i] = columnBool
+			}
+		}
+		rc.bufs = make([][]byte, rc.nc)
+	}
+	return rc.kinds
+}
+
 // Next move cursor to next.
+//
+// TEXT and BLOB values are stored into dest as []byte backed by a buffer
+// that is reused by the same column on the following rows, so they are only
+// valid until the next call to Next. Callers of the driver rows that put a
+// sql.RawBytes in dest[i] get a sql.RawBytes referring to SQLite's memory
+// without any copy instead, valid until the next call to Next or Close.
+// database/sql never does so, and copies the values of its own RawBytes.
 func (rc *SQLiteRows) Next(dest []driver.Value) error {
+	rc = rc.set()
 	rv := C.sqlite3_step(rc.s.s)
 	if rv == C.SQLITE_DONE {
+		rc.finishStatus()
 		return io.EOF
 	}
 	if rv != C.SQLITE_ROW {
+		rc.finishStatus()
 		rv = C.sqlite3_reset(rc.s.s)
 		if rv != C.SQLITE_OK {
 			return rc.s.c.lastError()
@@ -935,81 +1415,68 @@ func (rc *SQLiteRows) Next(dest []driver.Value) error {
 		return nil
 	}
 
-	rc.DeclTypes()
+	kinds := rc.columnKinds()
 
 	for i := range dest {
-		switch C.sqlite3_column_type(rc.s.s, C.int(i)) {
+		col := C.int(i)
+		switch C.sqlite3_column_type(rc.s.s, col) {
 		case C.SQLITE_INTEGER:
-			val := int64(C.sqlite3_column_int64(rc.s.s, C.int(i)))
-			switch rc.decltype[i] {
-			case "timestamp", "datetime", "date":
-				var t time.Time
-				// Assume a millisecond unix timestamp if it's 13 digits -- too
-				// large to be a reasonable timestamp in seconds.
-				if val > 1e12 || val < -1e12 {
-					val *= int64(time.Millisecond) // convert ms to nsec
-				} else {
-					val *= int64(time.Second) // convert sec to nsec
-				}
-				t = time.Unix(0, val).UTC()
+			val := int64(C.sqlite3_column_int64(rc.s.s, col))
+			switch kinds[i] {
+			case columnTime:
+				t := unixTime(val)
 				if rc.s.c.loc != nil {
 					t = t.In(rc.s.c.loc)
 				}
 				dest[i] = t
-			case "boolean":
+			case columnBool:
 				dest[i] = val > 0
 			default:
 				dest[i] = val
 			}
 		case C.SQLITE_FLOAT:
-			dest[i] = float64(C.sqlite3_column_double(rc.s.s, C.int(i)))
+			dest[i] = float64(C.sqlite3_column_double(rc.s.s, col))
 		case C.SQLITE_BLOB:
-			p := C.sqlite3_column_blob(rc.s.s, C.int(i))
+			p := C.sqlite3_column_blob(rc.s.s, col)
 			if p == nil {
 				dest[i] = nil
 				continue
 			}
-			n := int(C.sqlite3_column_bytes(rc.s.s, C.int(i)))
-			switch dest[i].(type) {
-			case sql.RawBytes:
-				dest[i] = (*[1 << 30]byte)(unsafe.Pointer(p))[0:n]
-			default:
-				slice := make([]byte, n)
-				copy(slice[:], (*[1 << 30]byte)(unsafe.Pointer(p))[0:n])
-				dest[i] = slice
-			}
+			n := int(C.sqlite3_column_bytes(rc.s.s, col))
+			dest[i] = rc.bytes(i, dest[i], p, n)
 		case C.SQLITE_NULL:
 			dest[i] = nil
 		case C.SQLITE_TEXT:
-			var err error
-			var timeVal time.Time
-
-			n := int(C.sqlite3_column_bytes(rc.s.s, C.int(i)))
-			s := C.GoStringN((*C.char)(unsafe.Pointer(C.sqlite3_column_text(rc.s.s, C.int(i)))), C.int(n))
-
-			switch rc.decltype[i] {
-			case "timestamp", "datetime", "date":
-				var t time.Time
-				s = strings.TrimSuffix(s, "Z")
-				for _, format := range SQLiteTimestampFormats {
-					if timeVal, err = time.ParseInLocation(format, s, time.UTC); err == nil {
-						t = timeVal
-						break
-					}
-				}
-				if err != nil {
-					// The column is a time value, so return the zero time on parse failure.
-					t = time.Time{}
-				}
-				if rc.s.c.loc != nil {
-					t = t.In(rc.s.c.loc)
-				}
-				dest[i] = t
-			default:
-				dest[i] = []byte(s)
+			p := unsafe.Pointer(C.sqlite3_column_text(rc.s.s, col))
+			n := int(C.sqlite3_column_bytes(rc.s.s, col))
+			if kinds[i] != columnTime {
+				dest[i] = rc.bytes(i, dest[i], p, n)
+				continue
 			}
 
+			// The column is a time value, so return the zero time on parse failure.
+			t, _ := parseTime(C.GoStringN((*C.char)(p), C.int(n)))
+			if rc.s.c.loc != nil {
+				t = t.In(rc.s.c.loc)
+			}
+			dest[i] = t
 		}
 	}
 	return nil
 }
+
+// bytes returns the n bytes at p as the value of column i. The bytes are
+// copied into the column's buffer, unless the previous value d is a
+// sql.RawBytes, which then refers to them.
+func (rc *SQLiteRows) bytes(i int, d driver.Value, p unsafe.Pointer, n int) driver.Value {
+	src := (*[1 << 30]byte)(p)[:n:n]
+	if _, ok := d.(sql.RawBytes); ok {
+		return sql.RawBytes(src)
+	}
+	b := append(rc.bufs[i][:0], src...)
+	if b == nil {
+		b = []byte{}
+	}
+	rc.bufs[i] = b
+	return b
+}
@@ -13,16 +13,24 @@ package sqlite3
 #include <sqlite3.h>
 #endif
 #include <stdlib.h>
+#include <stdint.h>
 // These wrappers are necessary because SQLITE_TRANSIENT
 // is a pointer constant, and cgo doesn't translate them correctly.
 
+// A NULL pointer would make the result NULL instead of the empty string.
 static inline void my_result_text(sqlite3_context *ctx, char *p, int np) {
-	sqlite3_result_text(ctx, p, np, SQLITE_TRANSIENT);
+	sqlite3_result_text(ctx, p ? p : "", np, SQLITE_TRANSIENT);
 }
 
 static inline void my_result_blob(sqlite3_context *ctx, void *p, int np) {
 	sqlite3_result_blob(ctx, p, np, SQLITE_TRANSIENT);
 }
+
+void auxDataDestroy(void*);
+
+static inline void _sqlite3_set_auxdata(sqlite3_context *ctx, int n, uintptr_t h) {
+	sqlite3_set_auxdata(ctx, n, (void*)h, auxDataDestroy);
+}
 */
 import "C"
 
@@ -37,6 +45,39 @@ const i64 = unsafe.Sizeof(int(0)) > 4
 // SQLiteContext behave sqlite3_context
 type SQLiteContext C.sqlite3_context
 
+// Conn returns the connection the function or the virtual table column is
+// evaluated on, or nil if it was not opened by this package. It doesn't use
+// sqlite3_user_data, which the contexts of virtual table columns lack.
+func (c *SQLiteContext) Conn() *SQLiteConn {
+	db := C.sqlite3_context_db_handle((*C.sqlite3_context)(c))
+	if conn, ok := conns.Load(db); ok {
+		return conn.(*SQLiteConn)
+	}
+	return nil
+}
+
+// GetAuxData returns the value associated by SetAuxData with the nth
+// argument of the function, or nil if there is none.
+// See: sqlite3_get_auxdata, https://www.sqlite.org/c3ref/get_auxdata.html
+func (c *SQLiteContext) GetAuxData(n int) interface{} {
+	p := C.sqlite3_get_auxdata((*C.sqlite3_context)(c), C.int(n))
+	if p == nil {
+		return nil
+	}
+	return lookupHandle(uintptr(p))
+}
+
+// SetAuxData associates v with the nth argument of the function, so that
+// later calls of the same function in the statement can get it back with
+// GetAuxData instead of computing it again. SQLite keeps v only as long as
+// the argument is a constant, such as a literal or a bound parameter, and
+// may drop it at any time, so callers must always be ready to recompute it.
+// See: sqlite3_set_auxdata, https://www.sqlite.org/c3ref/get_auxdata.html
+func (c *SQLiteContext) SetAuxData(n int, v interface{}) {
+	h := newHandle(c.Conn(), v)
+	C._sqlite3_set_auxdata((*C.sqlite3_context)(c), C.int(n), C.uintptr_t(h))
+}
+
 // ResultBool sets the result of an SQL function.
 func (c *SQLiteContext) ResultBool(b bool) {
 	if b {
@@ -66,6 +107,13 @@ func (c *SQLiteContext) ResultDouble(d float64) {
 	C.sqlite3_result_double((*C.sqlite3_context)(c), C.double(d))
 }
 
+// ResultError sets the result of an SQL function to an error with the
+// message of err.
+// See: sqlite3_result_error, http://sqlite.org/c3ref/result_blob.html
+func (c *SQLiteContext) ResultError(err error) {
+	callbackError((*C.sqlite3_context)(c), err)
+}
+
 // ResultInt sets the result of an SQL function.
 // See: sqlite3_result_int, http://sqlite.org/c3ref/result_blob.html
 func (c *SQLiteContext) ResultInt(i int) {
@@ -96,6 +144,13 @@ func (c *SQLiteContext) ResultText(s string) {
 	C.my_result_text((*C.sqlite3_context)(c), cs, l)
 }
 
+// ResultSubtype sets the subtype of the result of an SQL function, which
+// other functions such as those of the JSON1 extension can read.
+// See: sqlite3_result_subtype, https://www.sqlite.org/c3ref/result_subtype.html
+func (c *SQLiteContext) ResultSubtype(t uint) {
+	C.sqlite3_result_subtype((*C.sqlite3_context)(c), C.uint(t))
+}
+
 // ResultZeroblob sets the result of an SQL function.
 // See: sqlite3_result_zeroblob, http://sqlite.org/c3ref/result_blob.html
 func (c *SQLiteContext) ResultZeroblob(n int) {
@@ -22,6 +22,15 @@ func (c *SQLiteConn) Ping(ctx context.Context) error {
 	return nil
 }
 
+// CheckNamedValue implement NamedValueChecker, to bind Pointer arguments as
+// they are.
+func (c *SQLiteConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if _, ok := nv.Value.(Pointer); ok {
+		return nil
+	}
+	return driver.ErrSkip
+}
+
 // QueryContext implement QueryerContext.
 func (c *SQLiteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
 	list := make([]namedValue, len(args))
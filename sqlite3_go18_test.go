@@ -10,6 +10,7 @@ package sqlite3
 import (
 	"database/sql"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -48,3 +49,58 @@ func TestNamedParams(t *testing.T) {
 		t.Error("Failed to db.QueryRow: not matched results")
 	}
 }
+
+func TestMultipleResultSets(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+	create table foo (id integer);
+	insert into foo(id) values(?);
+	select ?;
+	insert into foo(id) values(?);
+	select id from foo order by id;
+	select count(*) from foo where id > ?; -- trailing comment
+	`, 1, "a", 2, 1)
+	if err != nil {
+		t.Fatal("Failed to call db.Query:", err)
+	}
+	defer rows.Close()
+
+	expected := [][]interface{}{
+		{"a"},
+		{int64(1), int64(2)},
+		{int64(1)},
+	}
+	for i, want := range expected {
+		if i > 0 && !rows.NextResultSet() {
+			t.Fatalf("Expected result set %d, got error: %v", i, rows.Err())
+		}
+		var got []interface{}
+		for rows.Next() {
+			var v interface{}
+			if err := rows.Scan(&v); err != nil {
+				t.Fatal("Failed to scan:", err)
+			}
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			got = append(got, v)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal("Failed to iterate:", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Result set %d: got %v, want %v", i, got, want)
+		}
+	}
+	if rows.NextResultSet() {
+		t.Fatal("Expected no more result sets")
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#ifndef USE_LIBSQLITE3
+#include <sqlite3-binding.h>
+#else
+#include <sqlite3.h>
+#endif
+
+static sqlite3_int64
+_sqlite3_hard_heap_limit64(sqlite3_int64 n) {
+#if SQLITE_VERSION_NUMBER >= 3031000
+  return sqlite3_hard_heap_limit64(n);
+#else
+  return -1;
+#endif
+}
+*/
+import "C"
+
+// SoftHeapLimit sets the soft limit, in bytes, on the heap used by SQLite
+// in this process and returns the prior limit. Zero disables the limit and
+// a negative value only reads the current one. When the limit is exceeded,
+// SQLite tries to release cached memory before allocating more, but
+// allocations never fail because of it.
+// See: https://www.sqlite.org/c3ref/hard_heap_limit64.html
+func SoftHeapLimit(n int64) int64 {
+	return int64(C.sqlite3_soft_heap_limit64(C.sqlite3_int64(n)))
+}
+
+// HardHeapLimit sets the hard limit, in bytes, on the heap used by SQLite in
+// this process and returns the prior limit. Zero disables the limit and a
+// negative value only reads the current one. Allocations that would exceed
+// the limit fail with SQLITE_NOMEM.
+//
+// SQLite versions before 3.31.0 have no hard heap limit; HardHeapLimit then
+// does nothing and returns -1.
+// See: https://www.sqlite.org/c3ref/hard_heap_limit64.html
+func HardHeapLimit(n int64) int64 {
+	return int64(C._sqlite3_hard_heap_limit64(C.sqlite3_int64(n)))
+}
+
+// ReleaseMemory frees as much heap memory as possible from the database
+// connection, such as unused pages of its cache.
+// See: https://www.sqlite.org/c3ref/db_release_memory.html
+func (c *SQLiteConn) ReleaseMemory() error {
+	rv := C.sqlite3_db_release_memory(c.db)
+	if rv != C.SQLITE_OK {
+		return c.lastError()
+	}
+	return nil
+}
+
+// CacheFlush writes the dirty pages of the connection's cache to disk
+// without committing the open transaction, so that they can be freed.
+// See: https://www.sqlite.org/c3ref/db_cacheflush.html
+func (c *SQLiteConn) CacheFlush() error {
+	rv := C.sqlite3_db_cacheflush(c.db)
+	if rv != C.SQLITE_OK {
+		return c.lastError()
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"testing"
+)
+
+func TestHeapLimit(t *testing.T) {
+	old := SoftHeapLimit(-1)
+	defer SoftHeapLimit(old)
+	SoftHeapLimit(64 << 20)
+	if v := SoftHeapLimit(-1); v != 64<<20 {
+		t.Errorf("Expected soft heap limit %d, got %d", 64<<20, v)
+	}
+
+	_, version, _ := Version()
+	if version < 3031000 {
+		if v := HardHeapLimit(-1); v != -1 {
+			t.Errorf("Expected -1 without hard heap limit support, got %d", v)
+		}
+		return
+	}
+	old = HardHeapLimit(-1)
+	defer HardHeapLimit(old)
+	HardHeapLimit(128 << 20)
+	if v := HardHeapLimit(-1); v != 128<<20 {
+		t.Errorf("Expected hard heap limit %d, got %d", 128<<20, v)
+	}
+}
+
+func TestReleaseMemory(t *testing.T) {
+	d := SQLiteDriver{}
+	conn, err := d.Open(":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer conn.Close()
+	c := conn.(*SQLiteConn)
+
+	if _, err := c.Exec("create table foo (id integer)", nil); err != nil {
+		t.Fatal("Failed to create table:", err)
+	}
+	if _, err := c.Exec("begin; insert into foo values (1)", nil); err != nil {
+		t.Fatal("Failed to insert:", err)
+	}
+	if err := c.CacheFlush(); err != nil {
+		t.Fatal("Failed to flush cache:", err)
+	}
+	if _, err := c.Exec("commit", nil); err != nil {
+		t.Fatal("Failed to commit:", err)
+	}
+	if err := c.ReleaseMemory(); err != nil {
+		t.Fatal("Failed to release memory:", err)
+	}
+}
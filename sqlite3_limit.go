@@ -0,0 +1,65 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#ifndef USE_LIBSQLITE3
+#include <sqlite3-binding.h>
+#else
+#include <sqlite3.h>
+#endif
+*/
+import "C"
+
+// Limit identifies a run-time limit of a database connection.
+// See: https://www.sqlite.org/c3ref/c_limit_attached.html
+type Limit int
+
+// Run-time limits understood by SQLiteConn.SetLimit and SQLiteConn.GetLimit.
+const (
+	LimitLength            Limit = C.SQLITE_LIMIT_LENGTH
+	LimitSQLLength         Limit = C.SQLITE_LIMIT_SQL_LENGTH
+	LimitColumn            Limit = C.SQLITE_LIMIT_COLUMN
+	LimitExprDepth         Limit = C.SQLITE_LIMIT_EXPR_DEPTH
+	LimitCompoundSelect    Limit = C.SQLITE_LIMIT_COMPOUND_SELECT
+	LimitVDBEOp            Limit = C.SQLITE_LIMIT_VDBE_OP
+	LimitFunctionArg       Limit = C.SQLITE_LIMIT_FUNCTION_ARG
+	LimitAttached          Limit = C.SQLITE_LIMIT_ATTACHED
+	LimitLikePatternLength Limit = C.SQLITE_LIMIT_LIKE_PATTERN_LENGTH
+	LimitVariableNumber    Limit = C.SQLITE_LIMIT_VARIABLE_NUMBER
+	LimitTriggerDepth      Limit = C.SQLITE_LIMIT_TRIGGER_DEPTH
+	LimitWorkerThreads     Limit = C.SQLITE_LIMIT_WORKER_THREADS
+)
+
+// limitNames maps the DSN parameters "_limit_<name>" to their limit.
+var limitNames = map[string]Limit{
+	"length":              LimitLength,
+	"sql_length":          LimitSQLLength,
+	"column":              LimitColumn,
+	"expr_depth":          LimitExprDepth,
+	"compound_select":     LimitCompoundSelect,
+	"vdbe_op":             LimitVDBEOp,
+	"function_arg":        LimitFunctionArg,
+	"attached":            LimitAttached,
+	"like_pattern_length": LimitLikePatternLength,
+	"variable_number":     LimitVariableNumber,
+	"trigger_depth":       LimitTriggerDepth,
+	"worker_threads":      LimitWorkerThreads,
+}
+
+// GetLimit returns the current value of a run-time limit.
+// See: https://www.sqlite.org/c3ref/limit.html
+func (c *SQLiteConn) GetLimit(id Limit) int {
+	return int(C.sqlite3_limit(c.db, C.int(id), -1))
+}
+
+// SetLimit changes a run-time limit and returns its prior value. A negative
+// value leaves the limit unchanged. Values larger than the hard upper bound
+// compiled into SQLite are silently truncated to that bound.
+// See: https://www.sqlite.org/c3ref/limit.html
+func (c *SQLiteConn) SetLimit(id Limit, newVal int) int {
+	return int(C.sqlite3_limit(c.db, C.int(id), C.int(newVal)))
+}
@@ -0,0 +1,84 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLimit(t *testing.T) {
+	d := SQLiteDriver{}
+	conn, err := d.Open(":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer conn.Close()
+	c := conn.(*SQLiteConn)
+
+	old := c.SetLimit(LimitSQLLength, 20)
+	if old <= 20 {
+		t.Fatalf("Unexpected default SQL length limit: %d", old)
+	}
+	if v := c.GetLimit(LimitSQLLength); v != 20 {
+		t.Fatalf("Expected SQL length limit 20, got %d", v)
+	}
+	if _, err := c.Exec("select 1 where 1 = 1 and 2 = 2", nil); err == nil {
+		t.Fatal("Expected SQL longer than the limit to fail")
+	}
+	c.SetLimit(LimitSQLLength, old)
+	if _, err := c.Exec("select 1 where 1 = 1 and 2 = 2", nil); err != nil {
+		t.Fatal("Failed to run query after restoring limit:", err)
+	}
+}
+
+func TestLimitDSN(t *testing.T) {
+	limits := map[Limit]int{}
+	sql.Register("sqlite3_LimitDSN", &SQLiteDriver{
+		Limits: map[Limit]int{LimitAttached: 5, LimitColumn: 100},
+		ConnectHook: func(conn *SQLiteConn) error {
+			for _, id := range []Limit{LimitAttached, LimitVariableNumber, LimitColumn} {
+				limits[id] = conn.GetLimit(id)
+			}
+			return nil
+		},
+	})
+
+	db, err := sql.Open("sqlite3_LimitDSN", ":memory:?_limit_attached=1&_limit_variable_number=2")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec("attach ':memory:' as one"); err != nil {
+		t.Fatal("Failed to attach a database:", err)
+	}
+	if _, err := db.Exec("attach ':memory:' as two"); err == nil {
+		t.Fatal("Expected the second ATTACH to fail")
+	}
+	// The DSN overrides the limits of the driver, which apply otherwise.
+	want := map[Limit]int{LimitAttached: 1, LimitVariableNumber: 2, LimitColumn: 100}
+	if !reflect.DeepEqual(limits, want) {
+		t.Fatalf("Expected the limits %v before ConnectHook, got %v", want, limits)
+	}
+	if _, err := db.Exec("select ?, ?, ?", 1, 2, 3); err == nil || !strings.Contains(err.Error(), "too many SQL variables") {
+		t.Fatal("Expected too many SQL variables, got", err)
+	}
+
+	for _, dsn := range []string{":memory:?_limit_bogus=1", ":memory:?_limit_column=-1", ":memory:?_limit_column=x"} {
+		db, err := sql.Open("sqlite3", dsn)
+		if err != nil {
+			t.Fatal("Failed to open database:", err)
+		}
+		if err := db.Ping(); err == nil {
+			t.Errorf("Expected %q to be rejected", dsn)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#ifndef USE_LIBSQLITE3
+#include <sqlite3-binding.h>
+#else
+#include <sqlite3.h>
+#endif
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+var memoryDBIndex uint64
+
+var (
+	memdbOnce sync.Once
+	memdb     bool
+)
+
+// hasMemdb reports whether the library provides the memdb VFS, which
+// appeared in SQLite 3.36.0.
+func hasMemdb() bool {
+	memdbOnce.Do(func() {
+		name := C.CString("memdb")
+		defer C.free(unsafe.Pointer(name))
+		memdb = C.sqlite3_vfs_find(name) != nil
+	})
+	return memdb
+}
+
+// MemoryDSN returns a DSN for a new, private in-memory database.
+//
+// Unlike ":memory:", which gives every pooled connection its own empty
+// database, all connections opened with the returned DSN share the same
+// database. Each call returns a distinct DSN, so two sql.DB instances never
+// see each other's data even when they are created with the same name. The
+// name only serves to make the database recognizable while debugging.
+//
+// The database uses the memdb VFS, so its connections lock it like a file
+// and wait on the busy timeout. With SQLite versions before 3.36.0, it
+// lives in a shared cache instead, where concurrent writers may see
+// SQLITE_LOCKED instead of waiting.
+//
+// SQLite frees the database when the last connection to it is closed.
+// database/sql closes idle connections when SetMaxIdleConns is 0 or
+// SetConnMaxLifetime expires them, and the data is then lost while the
+// sql.DB remains usable with a new, empty database. To keep the data, hold
+// a connection for as long as it is needed, for instance with sql.DB.Conn.
+//
+// The returned DSN already contains a query string; further parameters, such
+// as "_loc" or "_foreign_keys", must be appended with "&".
+func MemoryDSN(name string) string {
+	n := atomic.AddUint64(&memoryDBIndex, 1)
+	if hasMemdb() {
+		return fmt.Sprintf("file:/go-sqlite3-memory-%d-%s?vfs=memdb", n, url.PathEscape(name))
+	}
+	return fmt.Sprintf("file:go-sqlite3-memory-%d-%s?mode=memory&cache=shared", n, url.PathEscape(name))
+}
+
+// NewMemoryDB opens a private in-memory database shared by all connections
+// of the returned sql.DB. The database is freed when the sql.DB has no
+// open connections left. See MemoryDSN for details.
+func NewMemoryDB(name string) (*sql.DB, error) {
+	return sql.Open("sqlite3", MemoryDSN(name))
+}
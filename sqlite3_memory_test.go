@@ -0,0 +1,133 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryDB(t *testing.T) {
+	db, err := NewMemoryDB("test/mem?db")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(4)
+
+	if _, err := db.Exec("create table foo (id integer)"); err != nil {
+		t.Fatal("Failed to create table:", err)
+	}
+	if _, err := db.Exec("insert into foo values (1)"); err != nil {
+		t.Fatal("Failed to insert:", err)
+	}
+
+	// Hold a connection busy so that the next query must use another one.
+	rows, err := db.Query("select id from foo")
+	if err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	defer rows.Close()
+
+	var n int
+	if err := db.QueryRow("select count(*) from foo").Scan(&n); err != nil {
+		t.Fatal("Failed to query from second connection:", err)
+	}
+	if n != 1 {
+		t.Fatalf("Expected 1 row, got %d", n)
+	}
+
+	other, err := NewMemoryDB("test/mem?db")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer other.Close()
+	if _, err := other.Exec("select * from foo"); err == nil {
+		t.Fatal("Expected databases with the same name to be isolated")
+	}
+}
+
+func TestMemoryDBNoIdleConns(t *testing.T) {
+	db, err := NewMemoryDB("noidle")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+	db.SetMaxIdleConns(0)
+
+	// Each statement closes its connection, and the database with it.
+	if _, err := db.Exec("create table foo (id integer)"); err != nil {
+		t.Fatal("Failed to create table:", err)
+	}
+	if _, err := db.Exec("select * from foo"); err == nil {
+		t.Fatal("Expected the database to be freed with its last connection")
+	}
+
+	// A held connection keeps the database alive for the others.
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal("Failed to get connection:", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), "create table foo (id integer)"); err != nil {
+		t.Fatal("Failed to create table:", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := db.Exec("insert into foo values (?)", i); err != nil {
+			t.Fatal("Failed to insert:", err)
+		}
+	}
+	var n int
+	if err := db.QueryRow("select count(*) from foo").Scan(&n); err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	if n != 3 {
+		t.Fatalf("Expected 3 rows, got %d", n)
+	}
+}
+
+func TestMemoryDBConcurrentReader(t *testing.T) {
+	if !hasMemdb() {
+		t.Skip("the memdb VFS requires SQLite 3.36.0")
+	}
+	dsn := MemoryDSN("reader")
+	if !strings.Contains(dsn, "vfs=memdb") {
+		t.Fatalf("Expected a memdb DSN, got %q", dsn)
+	}
+	db, err := NewMemoryDB("reader")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("create table foo (id integer); insert into foo values (1)"); err != nil {
+		t.Fatal("Failed to create table:", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal("Failed to begin:", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("insert into foo values (2)"); err != nil {
+		t.Fatal("Failed to insert:", err)
+	}
+
+	// The reader waits on the busy timeout for the writer, where a shared
+	// cache would fail at once with SQLITE_LOCKED.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		tx.Commit()
+	}()
+	var n int
+	if err := db.QueryRow("select count(*) from foo").Scan(&n); err != nil {
+		t.Fatal("Failed to read while writing:", err)
+	}
+	if n != 2 {
+		t.Fatalf("Expected 2 rows, got %d", n)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#ifndef USE_LIBSQLITE3
+#include <sqlite3-binding.h>
+#else
+#include <sqlite3.h>
+#endif
+#include <stdint.h>
+
+void auxDataDestroy(void*);
+
+// Pointers need SQLite 3.20.0. Older versions fail to bind them, and see
+// none in values.
+#define GO_POINTER_TYPE "go-sqlite3-pointer"
+
+static int _sqlite3_bind_pointer(sqlite3_stmt *stmt, int n, uintptr_t h) {
+#if SQLITE_VERSION_NUMBER >= 3020000
+	return sqlite3_bind_pointer(stmt, n, (void*)h, GO_POINTER_TYPE, auxDataDestroy);
+#else
+	auxDataDestroy((void*)h);
+	return SQLITE_MISUSE;
+#endif
+}
+
+static void _sqlite3_result_pointer(sqlite3_context *ctx, uintptr_t h) {
+#if SQLITE_VERSION_NUMBER >= 3020000
+	sqlite3_result_pointer(ctx, (void*)h, GO_POINTER_TYPE, auxDataDestroy);
+#else
+	auxDataDestroy((void*)h);
+	sqlite3_result_error(ctx, "pointers require SQLite 3.20.0", -1);
+#endif
+}
+
+static uintptr_t _sqlite3_value_pointer(sqlite3_value *v) {
+#if SQLITE_VERSION_NUMBER >= 3020000
+	return (uintptr_t)sqlite3_value_pointer(v, GO_POINTER_TYPE);
+#else
+	return 0;
+#endif
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Pointer passes a Go value through SQL, for instance to the carray table
+// function of SQLiteDriver.CArray, as an argument of a statement or a result
+// of a function. SQL sees it as NULL, but the functions registered with
+// RegisterFunc get the Pointer in their interface{} arguments, as well as
+// the Filter method of virtual tables, and RawFunc with SQLiteValue.Pointer.
+// The value is only used by Go code, and can be of any type.
+//
+// Pointers require SQLite 3.20.0 or later.
+// See: https://www.sqlite.org/bindptr.html
+type Pointer struct {
+	Value interface{}
+}
+
+var pointerType = reflect.TypeOf(Pointer{})
+
+func (s *SQLiteStmt) bindPointer(n C.int, p Pointer) error {
+	rv := C._sqlite3_bind_pointer(s.s, n, C.uintptr_t(newHandle(s.c, p.Value)))
+	if rv == C.SQLITE_MISUSE {
+		return errors.New("binding a Pointer requires SQLite 3.20.0")
+	}
+	if rv != C.SQLITE_OK {
+		return s.c.lastError()
+	}
+	return nil
+}
+
+// valuePointer returns the Pointer held by v, if any.
+func valuePointer(v *C.sqlite3_value) (Pointer, bool) {
+	h := C._sqlite3_value_pointer(v)
+	if h == 0 {
+		return Pointer{}, false
+	}
+	return Pointer{lookupHandle(uintptr(h))}, true
+}
+
+func callbackRetPointer(ctx *C.sqlite3_context, v reflect.Value) error {
+	C._sqlite3_result_pointer(ctx, C.uintptr_t(newHandle(nil, v.Interface().(Pointer).Value)))
+	return nil
+}
+
+// carray is the generator of the carray table function registered by
+// SQLiteDriver.CArray, which returns the elements of a slice passed as a
+// Pointer:
+//
+//	SELECT * FROM t WHERE id IN carray(?)
+func carray(args ...interface{}) (TableFuncIterator, error) {
+	// NULL is a nil []byte, and an omitted argument is nil.
+	if b, ok := args[0].([]byte); args[0] == nil || (ok && b == nil) {
+		return func() ([]interface{}, error) { return nil, io.EOF }, nil
+	}
+	p, ok := args[0].(Pointer)
+	if !ok {
+		return nil, errors.New("carray: the argument must be a Pointer")
+	}
+	v := reflect.ValueOf(p.Value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("carray: expected a Pointer to a slice, got %T", p.Value)
+	}
+	i := 0
+	return func() ([]interface{}, error) {
+		if i >= v.Len() {
+			return nil, io.EOF
+		}
+		i++
+		return []interface{}{v.Index(i - 1).Interface()}, nil
+	}, nil
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestPointer(t *testing.T) {
+	if _, n, _ := Version(); n < 3020000 {
+		t.Skip("pointers require SQLite 3.20.0")
+	}
+	var conn *SQLiteConn
+	sql.Register("sqlite3_TestPointer", &SQLiteDriver{
+		CArray: true,
+		ConnectHook: func(c *SQLiteConn) error {
+			conn = c
+			err := c.RegisterFunc("seq", func(n int64) Pointer {
+				s := make([]int64, n)
+				for i := range s {
+					s[i] = int64(i + 1)
+				}
+				return Pointer{s}
+			}, false)
+			if err != nil {
+				return err
+			}
+			err = c.RegisterFunc("ptrtype", func(p Pointer) string {
+				return reflect.TypeOf(p.Value).String()
+			}, false)
+			if err != nil {
+				return err
+			}
+			return c.RegisterRawFunc("isptr", 1, func(ctx *SQLiteContext, args []SQLiteValue) {
+				_, ok := args[0].Pointer()
+				ctx.ResultBool(ok && args[0].Type() == ValueNull)
+			}, false)
+		},
+	})
+	db, err := sql.Open("sqlite3_TestPointer", ":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatal("Failed to create table:", err)
+	}
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		if _, err := db.Exec("INSERT INTO t VALUES (?, ?)", i+1, name); err != nil {
+			t.Fatal("Failed to insert:", err)
+		}
+	}
+	// carray keeps its table until the connection is closed.
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM carray(NULL)").Scan(&n); err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	if n != 0 {
+		t.Errorf("Expected no rows, got %d", n)
+	}
+	handles := countHandles(conn)
+
+	rows, err := db.Query("SELECT name FROM t WHERE id IN carray(?) ORDER BY id", Pointer{[]int64{2, 4, 9}})
+	if err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal("Failed to scan:", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if !reflect.DeepEqual(names, []string{"b", "d"}) {
+		t.Errorf("Expected b and d, got %v", names)
+	}
+
+	if err := db.QueryRow("SELECT count(*) FROM t WHERE name IN carray(?)", Pointer{[]string{"a", "e", "z"}}).Scan(&n); err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 rows, got %d", n)
+	}
+	if err := db.QueryRow("SELECT sum(value) FROM carray(seq(4))").Scan(&n); err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	if n != 10 {
+		t.Errorf("Expected the sum of seq(4) to be 10, got %d", n)
+	}
+
+	var typ string
+	var isptr, isnull bool
+	err = db.QueryRow("SELECT ptrtype(?1), isptr(?1), ?1 IS NULL", Pointer{map[string]int{}}).Scan(&typ, &isptr, &isnull)
+	if err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	if typ != "map[string]int" || !isptr || !isnull {
+		t.Errorf("Expected a NULL pointer to a map, got %q %v %v", typ, isptr, isnull)
+	}
+	if err := db.QueryRow("SELECT isptr(NULL)").Scan(&isptr); err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	if isptr {
+		t.Error("Expected NULL not to be a pointer")
+	}
+
+	for _, arg := range []interface{}{1, Pointer{42}} {
+		if err := db.QueryRow("SELECT count(*) FROM carray(?)", arg).Scan(&n); err == nil {
+			t.Errorf("Expected carray(%v) to fail", arg)
+		}
+	}
+
+	if got := countHandles(conn); got != handles {
+		t.Errorf("Expected the pointers to be released, got %d handles instead of %d", got, handles)
+	}
+}
+
+func TestCArrayDefaultDriver(t *testing.T) {
+	if _, n, _ := Version(); n < 3020000 {
+		t.Skip("pointers require SQLite 3.20.0")
+	}
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM carray(?) WHERE value > 1", Pointer{[]int{1, 2, 3}}).Scan(&n); err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 rows, got %d", n)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#ifndef USE_LIBSQLITE3
+#include <sqlite3-binding.h>
+#else
+#include <sqlite3.h>
+#endif
+#include <stdlib.h>
+#include <stdint.h>
+
+int rtreeQueryTrampoline(sqlite3_rtree_query_info*);
+void auxDataDestroy(void*);
+
+static int _sqlite3_rtree_query_callback(sqlite3 *db, const char *zQueryFunc, uintptr_t h) {
+	return sqlite3_rtree_query_callback(db, zQueryFunc, rtreeQueryTrampoline, (void*)h, auxDataDestroy);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"math"
+	"unsafe"
+)
+
+// RTreeWithin tells how much of a node or an entry of an R*Tree index
+// matches a query.
+type RTreeWithin int
+
+// Results of R*Tree queries.
+const (
+	RTreeNotWithin    RTreeWithin = C.NOT_WITHIN
+	RTreePartlyWithin RTreeWithin = C.PARTLY_WITHIN
+	RTreeFullyWithin  RTreeWithin = C.FULLY_WITHIN
+)
+
+// RTreeQueryInfo describes the node or entry of an R*Tree index that an
+// RTreeQueryFunc checks. Its slices are only valid during the call.
+// See: https://www.sqlite.org/rtree.html#the_sqlite3_rtree_query_callback_api
+type RTreeQueryInfo struct {
+	// Params are the arguments of the SQL function, converted to numbers,
+	// and Values the arguments as given.
+	Params []float64
+	Values []SQLiteValue
+	// Coords are the bounds of the node or entry, minimum then maximum for
+	// each dimension.
+	Coords []float64
+	// Level is the level of the node in the tree, and 0 for entries.
+	Level    int
+	MaxLevel int
+	// Rowid is the rowid of entries.
+	Rowid int64
+	// ParentScore and ParentWithin are the results of the parent node.
+	ParentScore  float64
+	ParentWithin RTreeWithin
+}
+
+// RTreeQueryFunc implements an R*Tree query. It tells how much of the node
+// or entry of info matches the query, and its score: the nodes and entries
+// are visited by increasing score, and those NotWithin are skipped. An
+// error aborts the statement, but SQLite only reports its code.
+type RTreeQueryFunc func(info RTreeQueryInfo) (within RTreeWithin, score float64, err error)
+
+// RegisterRTreeQuery registers an SQL function that queries R*Tree indexes
+// with impl, as the right-hand side of MATCH on their first column:
+//
+//	SELECT id FROM shapes WHERE id MATCH circle(45.3, 22.9, 5.0)
+//
+// The library must be compiled with SQLITE_ENABLE_RTREE.
+// See: https://www.sqlite.org/rtree.html#custom_r_tree_queries
+func (c *SQLiteConn) RegisterRTreeQuery(name string, impl RTreeQueryFunc) error {
+	if impl == nil {
+		return errors.New("nil function passed to RegisterRTreeQuery")
+	}
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	rv := C._sqlite3_rtree_query_callback(c.db, cname, C.uintptr_t(newHandle(c, impl)))
+	if rv != C.SQLITE_OK {
+		return c.lastError()
+	}
+	return nil
+}
+
+func (f RTreeQueryFunc) call(info *C.sqlite3_rtree_query_info) C.int {
+	var q RTreeQueryInfo
+	// sqlite3_rtree_dbl is a double unless SQLITE_RTREE_INT_ONLY is defined.
+	if n := int(info.nParam); n > 0 {
+		q.Params = (*[math.MaxInt32 / 8]float64)(unsafe.Pointer(info.aParam))[:n:n]
+		if info.apSqlParam != nil {
+			q.Values = (*[(math.MaxInt32 - 1) / unsafe.Sizeof(SQLiteValue{})]SQLiteValue)(unsafe.Pointer(info.apSqlParam))[:n:n]
+		}
+	}
+	if n := int(info.nCoord); n > 0 {
+		q.Coords = (*[math.MaxInt32 / 8]float64)(unsafe.Pointer(info.aCoord))[:n:n]
+	}
+	q.Level = int(info.iLevel)
+	q.MaxLevel = int(info.mxLevel)
+	q.Rowid = int64(info.iRowid)
+	q.ParentScore = float64(info.rParentScore)
+	q.ParentWithin = RTreeWithin(info.eParentWithin)
+	within, score, err := f(q)
+	if err != nil {
+		return C.SQLITE_ERROR
+	}
+	info.eWithin = C.int(within)
+	info.rScore = C.sqlite3_rtree_dbl(score)
+	return C.SQLITE_OK
+}
@@ -0,0 +1,107 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// circleQuery matches the boxes that intersect the circle of center x, y
+// and radius r given as parameters, scored by their distance to the center.
+func circleQuery(info RTreeQueryInfo) (RTreeWithin, float64, error) {
+	if len(info.Params) != 3 {
+		return 0, 0, errors.New("circle requires 3 arguments")
+	}
+	x, y, r := info.Params[0], info.Params[1], info.Values[2].Float()
+	minX, maxX, minY, maxY := info.Coords[0], info.Coords[1], info.Coords[2], info.Coords[3]
+	dx := math.Max(0, math.Max(minX-x, x-maxX))
+	dy := math.Max(0, math.Max(minY-y, y-maxY))
+	d := math.Hypot(dx, dy)
+	if d > r {
+		return RTreeNotWithin, d, nil
+	}
+	far := math.Hypot(math.Max(x-minX, maxX-x), math.Max(y-minY, maxY-y))
+	if far <= r {
+		return RTreeFullyWithin, d, nil
+	}
+	return RTreePartlyWithin, d, nil
+}
+
+func TestRTreeQuery(t *testing.T) {
+	sql.Register("sqlite3_TestRTreeQuery", &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			if err := conn.RegisterRTreeQuery("circle", circleQuery); err != nil {
+				return err
+			}
+			return conn.RegisterRTreeQuery("broken", func(info RTreeQueryInfo) (RTreeWithin, float64, error) {
+				panic("broken query")
+			})
+		},
+	})
+	db, err := sql.Open("sqlite3_TestRTreeQuery", ":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec("CREATE VIRTUAL TABLE points USING rtree(id, minX, maxX, minY, maxY)")
+	if err != nil && strings.Contains(err.Error(), "no such module") {
+		t.Skip("the library is compiled without R*Tree")
+	}
+	if err != nil {
+		t.Fatal("Failed to create table:", err)
+	}
+	for i := 0; i < 100; i++ {
+		x, y := float64(i%10), float64(i/10)
+		if _, err := db.Exec("INSERT INTO points VALUES (?, ?, ?, ?, ?)", i, x, x, y, y); err != nil {
+			t.Fatal("Failed to insert:", err)
+		}
+	}
+
+	rows, err := db.Query("SELECT id FROM points WHERE id MATCH circle(2, 3, 1.0)")
+	if err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal("Failed to scan:", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	rows.Close()
+	// The center comes first, and then the points at a distance of 1.
+	if len(ids) != 5 || ids[0] != 32 {
+		t.Fatalf("Expected 5 points starting with 32, got %v", ids)
+	}
+	want := map[int]bool{22: true, 31: true, 32: true, 33: true, 42: true}
+	got := map[int]bool{}
+	for _, id := range ids {
+		got[id] = true
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the points %v, got %v", want, got)
+	}
+
+	var n int
+	for _, query := range []string{
+		"SELECT count(*) FROM points WHERE id MATCH circle(2, 3)",
+		"SELECT count(*) FROM points WHERE id MATCH broken(1)",
+	} {
+		if err := db.QueryRow(query).Scan(&n); err == nil {
+			t.Errorf("Expected %q to fail", query)
+		}
+	}
+}
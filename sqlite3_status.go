@@ -0,0 +1,275 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#ifndef USE_LIBSQLITE3
+#include <sqlite3-binding.h>
+#else
+#include <sqlite3.h>
+#endif
+
+// Counters added after the bundled SQLite version. sqlite3_stmt_status
+// returns 0 for counters the library does not know about.
+#ifndef SQLITE_STMTSTATUS_REPREPARE
+# define SQLITE_STMTSTATUS_REPREPARE 5
+#endif
+#ifndef SQLITE_STMTSTATUS_RUN
+# define SQLITE_STMTSTATUS_RUN 6
+#endif
+#ifndef SQLITE_STMTSTATUS_MEMUSED
+# define SQLITE_STMTSTATUS_MEMUSED 99
+#endif
+#ifndef SQLITE_DBSTATUS_CACHE_SPILL
+# define SQLITE_DBSTATUS_CACHE_SPILL 12
+#endif
+
+static const int stmtStatusOps[] = {
+  SQLITE_STMTSTATUS_FULLSCAN_STEP,
+  SQLITE_STMTSTATUS_SORT,
+  SQLITE_STMTSTATUS_AUTOINDEX,
+  SQLITE_STMTSTATUS_VM_STEP,
+  SQLITE_STMTSTATUS_REPREPARE,
+  SQLITE_STMTSTATUS_RUN,
+  SQLITE_STMTSTATUS_MEMUSED,
+};
+#define STMT_STATUS_OPS (sizeof(stmtStatusOps) / sizeof(stmtStatusOps[0]))
+
+// Resets the statement, and its counters so that they only count the next
+// run. Called by SQLiteStmt.bind.
+int
+_sqlite3_reset_status(sqlite3_stmt *stmt) {
+  int i;
+  if (stmt == NULL) {
+    return SQLITE_OK;
+  }
+  for (i = 0; i < STMT_STATUS_OPS; i++) {
+    sqlite3_stmt_status(stmt, stmtStatusOps[i], 1);
+  }
+  return sqlite3_reset(stmt);
+}
+
+static void
+_sqlite3_stmt_status_all(sqlite3_stmt *stmt, int *values) {
+  int i;
+  for (i = 0; i < STMT_STATUS_OPS; i++) {
+    values[i] = sqlite3_stmt_status(stmt, stmtStatusOps[i], 0);
+  }
+}
+*/
+import "C"
+
+// StatusOp identifies a process wide status parameter.
+// See: https://www.sqlite.org/c3ref/c_status_malloc_count.html
+type StatusOp int
+
+// Status parameters understood by Status.
+const (
+	StatusMemoryUsed        StatusOp = C.SQLITE_STATUS_MEMORY_USED
+	StatusPagecacheUsed     StatusOp = C.SQLITE_STATUS_PAGECACHE_USED
+	StatusPagecacheOverflow StatusOp = C.SQLITE_STATUS_PAGECACHE_OVERFLOW
+	StatusMallocSize        StatusOp = C.SQLITE_STATUS_MALLOC_SIZE
+	StatusParserStack       StatusOp = C.SQLITE_STATUS_PARSER_STACK
+	StatusPagecacheSize     StatusOp = C.SQLITE_STATUS_PAGECACHE_SIZE
+	StatusMallocCount       StatusOp = C.SQLITE_STATUS_MALLOC_COUNT
+)
+
+// DBStatusOp identifies a database connection status parameter.
+// See: https://www.sqlite.org/c3ref/c_dbstatus_options.html
+type DBStatusOp int
+
+// Database connection status parameters understood by SQLiteConn.DBStatus.
+const (
+	DBStatusLookasideUsed     DBStatusOp = C.SQLITE_DBSTATUS_LOOKASIDE_USED
+	DBStatusCacheUsed         DBStatusOp = C.SQLITE_DBSTATUS_CACHE_USED
+	DBStatusSchemaUsed        DBStatusOp = C.SQLITE_DBSTATUS_SCHEMA_USED
+	DBStatusStmtUsed          DBStatusOp = C.SQLITE_DBSTATUS_STMT_USED
+	DBStatusLookasideHit      DBStatusOp = C.SQLITE_DBSTATUS_LOOKASIDE_HIT
+	DBStatusLookasideMissSize DBStatusOp = C.SQLITE_DBSTATUS_LOOKASIDE_MISS_SIZE
+	DBStatusLookasideMissFull DBStatusOp = C.SQLITE_DBSTATUS_LOOKASIDE_MISS_FULL
+	DBStatusCacheHit          DBStatusOp = C.SQLITE_DBSTATUS_CACHE_HIT
+	DBStatusCacheMiss         DBStatusOp = C.SQLITE_DBSTATUS_CACHE_MISS
+	DBStatusCacheWrite        DBStatusOp = C.SQLITE_DBSTATUS_CACHE_WRITE
+	DBStatusDeferredFKs       DBStatusOp = C.SQLITE_DBSTATUS_DEFERRED_FKS
+	DBStatusCacheUsedShared   DBStatusOp = C.SQLITE_DBSTATUS_CACHE_USED_SHARED
+	DBStatusCacheSpill        DBStatusOp = C.SQLITE_DBSTATUS_CACHE_SPILL /* 3.23.0 and later only */
+)
+
+// Status returns the current value and the highest recorded value of a
+// process wide status parameter. If reset is true, the highest recorded
+// value is set back to the current value.
+// See: https://www.sqlite.org/c3ref/status.html
+func Status(op StatusOp, reset bool) (current, highwater int64, err error) {
+	var cur, hi C.sqlite3_int64
+	var r C.int
+	if reset {
+		r = 1
+	}
+	rv := C.sqlite3_status64(C.int(op), &cur, &hi, r)
+	if rv != C.SQLITE_OK {
+		return 0, 0, Error{Code: ErrNo(rv)}
+	}
+	return int64(cur), int64(hi), nil
+}
+
+// DBStatus returns the current value and the highest recorded value of a
+// status parameter of the database connection. If reset is true, the
+// highest recorded value is set back to the current value.
+// See: https://www.sqlite.org/c3ref/db_status.html
+func (c *SQLiteConn) DBStatus(op DBStatusOp, reset bool) (current, highwater int, err error) {
+	var cur, hi C.int
+	var r C.int
+	if reset {
+		r = 1
+	}
+	rv := C.sqlite3_db_status(c.db, C.int(op), &cur, &hi, r)
+	if rv != C.SQLITE_OK {
+		return 0, 0, Error{Code: ErrNo(rv)}
+	}
+	return int(cur), int(hi), nil
+}
+
+// Stats is a snapshot of the commonly exported counters of a database
+// connection, along with the process wide memory usage.
+type Stats struct {
+	CacheHit          int // Pager cache hits
+	CacheMiss         int // Pager cache misses
+	CacheWrite        int // Dirty pages written to disk
+	CacheUsed         int // Bytes of heap used by the pager cache
+	LookasideUsed     int // Lookaside slots in use
+	LookasideHit      int // Allocations served from the lookaside
+	LookasideMissSize int // Allocations too large for the lookaside
+	LookasideMissFull int // Allocations missed because the lookaside was full
+	SchemaUsed        int // Bytes of heap used by the schema
+	StmtUsed          int // Bytes of heap used by prepared statements
+
+	MemoryUsed      int64 // Bytes of heap used by SQLite in this process
+	MemoryHighwater int64 // Highest value of MemoryUsed
+
+	StmtCacheHits   int64 // Prepared statements reused from the cache
+	StmtCacheMisses int64 // Prepared statements not found in the cache
+	StmtCacheErrors int64 // Failures to check the schema of cached statements
+}
+
+// Stats returns a snapshot of the connection counters. No counter is reset.
+func (c *SQLiteConn) Stats() (Stats, error) {
+	var st Stats
+	for _, v := range []struct {
+		op  DBStatusOp
+		dst *int
+	}{
+		{DBStatusCacheHit, &st.CacheHit},
+		{DBStatusCacheMiss, &st.CacheMiss},
+		{DBStatusCacheWrite, &st.CacheWrite},
+		{DBStatusCacheUsed, &st.CacheUsed},
+		{DBStatusLookasideUsed, &st.LookasideUsed},
+		{DBStatusLookasideHit, &st.LookasideHit},
+		{DBStatusLookasideMissSize, &st.LookasideMissSize},
+		{DBStatusLookasideMissFull, &st.LookasideMissFull},
+		{DBStatusSchemaUsed, &st.SchemaUsed},
+		{DBStatusStmtUsed, &st.StmtUsed},
+	} {
+		cur, hi, err := c.DBStatus(v.op, false)
+		if err != nil {
+			return Stats{}, err
+		}
+		switch v.op {
+		case DBStatusLookasideHit, DBStatusLookasideMissSize, DBStatusLookasideMissFull:
+			// These counters only report a highwater value.
+			*v.dst = hi
+		default:
+			*v.dst = cur
+		}
+	}
+	var err error
+	st.MemoryUsed, st.MemoryHighwater, err = Status(StatusMemoryUsed, false)
+	if err != nil {
+		return Stats{}, err
+	}
+	if c.cache != nil {
+		st.StmtCacheHits, st.StmtCacheMisses, st.StmtCacheErrors = c.cache.stats()
+	}
+	return st, nil
+}
+
+// StmtStatusCounter identifies a prepared statement counter.
+// See: https://www.sqlite.org/c3ref/c_stmtstatus_counter.html
+type StmtStatusCounter int
+
+// Prepared statement counters understood by SQLiteStmt.Status.
+const (
+	StmtStatusFullscanStep StmtStatusCounter = C.SQLITE_STMTSTATUS_FULLSCAN_STEP
+	StmtStatusSort         StmtStatusCounter = C.SQLITE_STMTSTATUS_SORT
+	StmtStatusAutoindex    StmtStatusCounter = C.SQLITE_STMTSTATUS_AUTOINDEX
+	StmtStatusVMStep       StmtStatusCounter = C.SQLITE_STMTSTATUS_VM_STEP
+	StmtStatusReprepare    StmtStatusCounter = C.SQLITE_STMTSTATUS_REPREPARE /* 3.20.0 and later only */
+	StmtStatusRun          StmtStatusCounter = C.SQLITE_STMTSTATUS_RUN       /* 3.20.0 and later only */
+	StmtStatusMemUsed      StmtStatusCounter = C.SQLITE_STMTSTATUS_MEMUSED   /* 3.20.0 and later only */
+)
+
+// StmtStatus is a snapshot of the counters of a prepared statement.
+type StmtStatus struct {
+	FullscanStep int // Forward steps in a full table scan
+	Sort         int // Sort operations
+	Autoindex    int // Rows inserted into automatic indexes
+	VMStep       int // Virtual machine operations
+	Reprepare    int // Automatic regenerations of the statement
+	Run          int // Completed or partial runs of the statement
+	MemUsed      int // Bytes of heap used by the statement
+}
+
+// Status returns the value of a prepared statement counter. The counters
+// start from zero each time the statement is executed or queried. If reset
+// is true, the counter is set back to zero after reading it.
+// See: https://www.sqlite.org/c3ref/stmt_status.html
+func (s *SQLiteStmt) Status(counter StmtStatusCounter, reset bool) int {
+	if s.s == nil {
+		return 0
+	}
+	var r C.int
+	if reset {
+		r = 1
+	}
+	return int(C.sqlite3_stmt_status(s.s, C.int(counter), r))
+}
+
+// status reads all the counters of the statement at once.
+func (s *SQLiteStmt) status() StmtStatus {
+	var v [7]C.int
+	if s.s != nil {
+		C._sqlite3_stmt_status_all(s.s, &v[0])
+	}
+	return StmtStatus{
+		FullscanStep: int(v[0]),
+		Sort:         int(v[1]),
+		Autoindex:    int(v[2]),
+		VMStep:       int(v[3]),
+		Reprepare:    int(v[4]),
+		Run:          int(v[5]),
+		MemUsed:      int(v[6]),
+	}
+}
+
+// Status returns the statement counters accumulated by this query. The
+// counters are complete once Next has returned io.EOF or the rows have been
+// closed; before that they reflect the progress made so far.
+func (rc *SQLiteRows) Status() StmtStatus {
+	rc = rc.set()
+	if rc.statusDone || rc.s.closed {
+		return rc.status
+	}
+	return rc.s.status()
+}
+
+// finishStatus records the final counters of the query. It must be called
+// before the statement is run again or finalized.
+func (rc *SQLiteRows) finishStatus() {
+	if rc.statusDone || rc.s.closed {
+		return
+	}
+	rc.status = rc.s.status()
+	rc.statusDone = true
+}
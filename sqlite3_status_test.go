@@ -0,0 +1,123 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"database/sql/driver"
+	"io"
+	"testing"
+)
+
+func TestStmtStatus(t *testing.T) {
+	d := SQLiteDriver{}
+	conn, err := d.Open(":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer conn.Close()
+	c := conn.(*SQLiteConn)
+
+	if _, err := c.Exec("create table foo (id integer, name text)", nil); err != nil {
+		t.Fatal("Failed to create table:", err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := c.Exec("insert into foo values (?, 'bar')", []driver.Value{int64(i)}); err != nil {
+			t.Fatal("Failed to insert:", err)
+		}
+	}
+
+	s, err := c.Prepare("select id from foo order by name")
+	if err != nil {
+		t.Fatal("Failed to prepare:", err)
+	}
+	defer s.Close()
+	stmt := s.(*SQLiteStmt)
+
+	for run := 0; run < 2; run++ {
+		rows, err := stmt.Query(nil)
+		if err != nil {
+			t.Fatal("Failed to query:", err)
+		}
+		dest := make([]driver.Value, 1)
+		n := 0
+		for {
+			if err := rows.Next(dest); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal("Failed to read rows:", err)
+			}
+			n++
+		}
+		if n != 10 {
+			t.Fatalf("Expected 10 rows, got %d", n)
+		}
+		st := rows.(*SQLiteRows).Status()
+		rows.Close()
+
+		// The counters of each run must not include the previous runs.
+		if st.FullscanStep != 9 {
+			t.Errorf("Run %d: expected 9 full scan steps, got %d", run, st.FullscanStep)
+		}
+		if st.Sort != 1 {
+			t.Errorf("Run %d: expected 1 sort, got %d", run, st.Sort)
+		}
+		if st.VMStep == 0 {
+			t.Errorf("Run %d: expected VM steps to be counted", run)
+		}
+	}
+
+	// The statement counters start again with each run.
+	if v := stmt.Status(StmtStatusSort, true); v != 1 {
+		t.Errorf("Expected 1 sort on the statement, got %d", v)
+	}
+	if v := stmt.Status(StmtStatusSort, false); v != 0 {
+		t.Errorf("Expected counter to be reset, got %d", v)
+	}
+}
+
+func TestDBStatus(t *testing.T) {
+	d := SQLiteDriver{}
+	conn, err := d.Open(":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer conn.Close()
+	c := conn.(*SQLiteConn)
+
+	if _, err := c.Exec("create table foo (id integer, name text)", nil); err != nil {
+		t.Fatal("Failed to create table:", err)
+	}
+
+	used, _, err := c.DBStatus(DBStatusSchemaUsed, false)
+	if err != nil {
+		t.Fatal("Failed to get schema memory:", err)
+	}
+	if used <= 0 {
+		t.Errorf("Expected schema memory to be used, got %d", used)
+	}
+	if _, _, err := c.DBStatus(DBStatusOp(1000), false); err == nil {
+		t.Error("Expected an error for an unknown status parameter")
+	}
+
+	cur, hi, err := Status(StatusMemoryUsed, false)
+	if err != nil {
+		t.Fatal("Failed to get memory used:", err)
+	}
+	if cur <= 0 || hi < cur {
+		t.Errorf("Unexpected memory used: current %d, highwater %d", cur, hi)
+	}
+
+	st, err := c.Stats()
+	if err != nil {
+		t.Fatal("Failed to get stats:", err)
+	}
+	if st.SchemaUsed != used {
+		t.Errorf("Expected SchemaUsed %d, got %d", used, st.SchemaUsed)
+	}
+	if st.MemoryUsed <= 0 || st.MemoryHighwater < st.MemoryUsed {
+		t.Errorf("Unexpected memory stats: %+v", st)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#ifndef USE_LIBSQLITE3
+#include <sqlite3-binding.h>
+#else
+#include <sqlite3.h>
+#endif
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"container/list"
+	"sync"
+	"unsafe"
+)
+
+// stmtCache keeps the most recently closed statements of a connection, keyed
+// by their SQL text, so that preparing the same query again doesn't need to
+// call sqlite3_prepare_v2.
+//
+// Statements are handed out by get and returned by put when the SQLiteStmt
+// that owned them is closed, so a cached statement is never used by two
+// SQLiteStmt at once. The lock is needed because statements may also be
+// closed by finalizers and by the goroutine watching the query context.
+//
+// SQLite only notices that a statement is stale when stepping it, which is
+// after the caller has seen its old result columns. So each statement
+// records the last schema version seen by the cache when it was prepared,
+// and on a hit get reads the current version and drops the statement if the
+// schema has changed since, on this connection or another one. Misses don't
+// read it, so preparing a new statement takes no lock. Only the version of
+// the main database is checked: the statements using attached databases
+// rely on SQLite preparing them again.
+type stmtCache struct {
+	mu      sync.Mutex
+	db      *C.sqlite3
+	size    int
+	lru     *list.List // of *stmtCacheEntry, most recently used first
+	entries map[string]*list.Element
+	closed  bool
+
+	// schema is the last schema version seen, or -1 before the first hit,
+	// and version and reload the statements that read it and that load the
+	// schema, prepared on first use.
+	schema  int64
+	version *C.sqlite3_stmt
+	reload  *C.sqlite3_stmt
+
+	hits   int64
+	misses int64
+	errors int64 // failures to read or load the schema
+}
+
+type stmtCacheEntry struct {
+	query  string
+	s      *C.sqlite3_stmt
+	t      string
+	schema int64
+}
+
+func newStmtCache(db *C.sqlite3, size int) *stmtCache {
+	return &stmtCache{
+		db:      db,
+		size:    size,
+		schema:  -1,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get removes the statement prepared for query from the cache, if it was
+// prepared against the current schema. It also returns the schema version
+// to record in the statements prepared on a miss.
+func (sc *stmtCache) get(query string) (*stmtCacheEntry, int64) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if e, ok := sc.entries[query]; ok {
+		sc.lru.Remove(e)
+		delete(sc.entries, query)
+		entry := e.Value.(*stmtCacheEntry)
+		if schema, ok := sc.schemaVersion(); ok && entry.schema == schema {
+			sc.hits++
+			return entry, schema
+		}
+		C.sqlite3_finalize(entry.s)
+	}
+	sc.misses++
+	return nil, sc.schema
+}
+
+// schemaVersion returns the schema version of the main database. When it
+// has changed, it also makes the connection load the new schema, which it
+// otherwise only does when stepping a statement prepared against the old
+// one, so that the statements prepared next are compiled against it. The
+// failures are counted in errors. The lock must be held.
+func (sc *stmtCache) schemaVersion() (int64, bool) {
+	schema, ok := sc.step(&sc.version, "PRAGMA schema_version")
+	if !ok {
+		sc.errors++
+		return 0, false
+	}
+	if schema != sc.schema {
+		// Reading a table verifies the schema, and SQLite prepares the
+		// statement again after loading it if it was stale. Until it
+		// succeeds, the statements keep the previous version and are
+		// dropped on their next hit.
+		if _, ok := sc.step(&sc.reload, "SELECT 1 FROM sqlite_master LIMIT 1"); !ok {
+			sc.errors++
+			return schema, true
+		}
+		sc.schema = schema
+	}
+	return schema, true
+}
+
+// step prepares the query into *s on first use, and returns the integer in
+// the first column of its first row. The lock must be held.
+func (sc *stmtCache) step(s **C.sqlite3_stmt, query string) (int64, bool) {
+	if *s == nil {
+		cquery := C.CString(query)
+		defer C.free(unsafe.Pointer(cquery))
+		var stmt *C.sqlite3_stmt
+		if C.sqlite3_prepare_v2(sc.db, cquery, -1, &stmt, nil) != C.SQLITE_OK {
+			return 0, false
+		}
+		*s = stmt
+	}
+	stmt := *s
+	defer C.sqlite3_reset(stmt)
+	switch C.sqlite3_step(stmt) {
+	case C.SQLITE_ROW:
+		return int64(C.sqlite3_column_int64(stmt, 0)), true
+	case C.SQLITE_DONE:
+		return 0, true
+	}
+	return 0, false
+}
+
+// put resets the statement and adds it to the cache. It returns false if
+// the statement can't be cached, in which case the caller must finalize it.
+func (sc *stmtCache) put(s *SQLiteStmt) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.closed {
+		return false
+	}
+	if _, ok := sc.entries[s.cacheKey]; ok {
+		// The same query was prepared twice; keep the one already cached.
+		return false
+	}
+	C.sqlite3_reset(s.s)
+	C.sqlite3_clear_bindings(s.s)
+	e := sc.lru.PushFront(&stmtCacheEntry{
+		query:  s.cacheKey,
+		s:      s.s,
+		t:      s.t,
+		schema: s.schema,
+	})
+	sc.entries[s.cacheKey] = e
+	for sc.lru.Len() > sc.size {
+		last := sc.lru.Back()
+		sc.lru.Remove(last)
+		entry := last.Value.(*stmtCacheEntry)
+		delete(sc.entries, entry.query)
+		C.sqlite3_finalize(entry.s)
+	}
+	return true
+}
+
+// clear finalizes all cached statements. The lock must be held.
+func (sc *stmtCache) clear() {
+	for e := sc.lru.Front(); e != nil; e = e.Next() {
+		C.sqlite3_finalize(e.Value.(*stmtCacheEntry).s)
+	}
+	sc.lru.Init()
+	sc.entries = make(map[string]*list.Element)
+}
+
+// close finalizes all cached statements and stops caching new ones.
+func (sc *stmtCache) close() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.clear()
+	C.sqlite3_finalize(sc.version)
+	C.sqlite3_finalize(sc.reload)
+	sc.version, sc.reload = nil, nil
+	sc.closed = true
+}
+
+func (sc *stmtCache) stats() (hits, misses, errors int64) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.hits, sc.misses, sc.errors
+}
@@ -0,0 +1,187 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestStmtCache(t *testing.T) {
+	tempFilename := TempFilename(t)
+	defer os.Remove(tempFilename)
+	d := SQLiteDriver{StmtCacheSize: 2}
+	conn, err := d.Open(tempFilename + "?_busy_timeout=0")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer conn.Close()
+	c := conn.(*SQLiteConn)
+
+	if _, err := c.Exec("create table foo (id integer, name text)", nil); err != nil {
+		t.Fatal("Failed to create table:", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.Exec("insert into foo values (?, 'bar')", []driver.Value{int64(i)}); err != nil {
+			t.Fatal("Failed to insert:", err)
+		}
+	}
+	st, err := c.Stats()
+	if err != nil {
+		t.Fatal("Failed to get stats:", err)
+	}
+	// The statements prepared before the first hit don't know the schema
+	// version, so the first hit only reads it.
+	if st.StmtCacheHits != 1 || st.StmtCacheMisses != 3 {
+		t.Fatalf("Expected 1 cache hit and 3 misses, got %d and %d", st.StmtCacheHits, st.StmtCacheMisses)
+	}
+
+	s1, err := c.Prepare("select count(*) from foo where id >= ?")
+	if err != nil {
+		t.Fatal("Failed to prepare:", err)
+	}
+	if err := s1.Close(); err != nil {
+		t.Fatal("Failed to close:", err)
+	}
+	s2, err := c.Prepare("select count(*) from foo where id >= ?")
+	if err != nil {
+		t.Fatal("Failed to prepare:", err)
+	}
+	if st, err := c.Stats(); err != nil || st.StmtCacheHits != 2 {
+		t.Fatalf("Expected the statement to be reused, got %+v, %v", st, err)
+	}
+	if s1.(*SQLiteStmt).s != nil {
+		t.Fatal("Expected the closed statement to release its handle")
+	}
+	s2.Close()
+
+	// Fill the cache with other statements so that the first one is evicted.
+	for _, q := range []string{"select 1", "select 2"} {
+		s, err := c.Prepare(q)
+		if err != nil {
+			t.Fatal("Failed to prepare:", err)
+		}
+		s.Close()
+	}
+	if len(c.cache.entries) != 2 {
+		t.Fatalf("Expected 2 cached statements, got %d", len(c.cache.entries))
+	}
+	if _, ok := c.cache.entries["select count(*) from foo where id >= ?"]; ok {
+		t.Fatal("Expected the least recently used statement to be evicted")
+	}
+
+	// A schema change, on this connection or another one, must be visible
+	// to the cached statements.
+	other, err := d.Open(tempFilename + "?_busy_timeout=0")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer other.Close()
+	query := func() []string {
+		s, err := c.Prepare("select * from foo")
+		if err != nil {
+			t.Fatal("Failed to prepare:", err)
+		}
+		defer s.Close()
+		rows, err := s.Query(nil)
+		if err != nil {
+			t.Fatal("Failed to query:", err)
+		}
+		defer rows.Close()
+		dest := make([]driver.Value, len(rows.Columns()))
+		for {
+			if err := rows.Next(dest); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal("Failed to read rows:", err)
+			}
+		}
+		return rows.Columns()
+	}
+	for i, alter := range []struct {
+		conn driver.Conn
+		stmt string
+	}{
+		{c, "/* comment */ alter table foo add column a text"},
+		{other, "alter table foo add column b text"},
+	} {
+		if cols := query(); len(cols) != 2+i {
+			t.Fatalf("Expected %d columns, got %v", 2+i, cols)
+		}
+		if _, err := alter.conn.(*SQLiteConn).Exec(alter.stmt, nil); err != nil {
+			t.Fatal("Failed to alter table:", err)
+		}
+		if cols := query(); len(cols) != 3+i {
+			t.Fatalf("Expected %d columns after %q, got %v", 3+i, alter.stmt, cols)
+		}
+	}
+	// A statement whose schema can't be checked is prepared again, and the
+	// failure is counted.
+	query()
+	if _, err := other.(*SQLiteConn).Exec("begin exclusive", nil); err != nil {
+		t.Fatal("Failed to lock database:", err)
+	}
+	s, err := c.Prepare("select * from foo")
+	if err != nil {
+		t.Fatal("Failed to prepare:", err)
+	}
+	s.Close()
+	if _, err := other.(*SQLiteConn).Exec("rollback", nil); err != nil {
+		t.Fatal("Failed to unlock database:", err)
+	}
+	if st, err := c.Stats(); err != nil || st.StmtCacheErrors != 1 {
+		t.Fatalf("Expected 1 cache error, got %+v, %v", st, err)
+	}
+	if cols := query(); len(cols) != 4 {
+		t.Fatalf("Expected 4 columns, got %v", cols)
+	}
+}
+
+func TestStmtCacheBindings(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:?_stmt_cache_size=10")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, want := range []int64{1, 2, 3} {
+		var got int64
+		if err := db.QueryRow("select ?", want).Scan(&got); err != nil {
+			t.Fatal("Failed to query:", err)
+		}
+		if got != want {
+			t.Fatalf("Expected %d, got %d", want, got)
+		}
+	}
+
+	// Statements abandoned halfway must be reset before they are reused.
+	rows, err := db.Query("select 1 union all select 2")
+	if err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	rows.Next()
+	rows.Close()
+	var n int
+	if err := db.QueryRow("select count(*) from (select 1 union all select 2)").Scan(&n); err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	rows, err = db.Query("select 1 union all select 2")
+	if err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	n = 0
+	for rows.Next() {
+		n++
+	}
+	rows.Close()
+	if n != 2 {
+		t.Fatalf("Expected 2 rows from reused statement, got %d", n)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#ifndef USE_LIBSQLITE3
+#include <sqlite3-binding.h>
+#else
+#include <sqlite3.h>
+#endif
+*/
+import "C"
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TableFuncIterator returns the rows of a table-valued function one at a
+// time, with one value per column. It returns io.EOF after the last row.
+type TableFuncIterator func() ([]interface{}, error)
+
+// TableFuncGenerator starts a call of a table-valued function. args holds
+// the arguments of the call, nil for those that weren't given.
+type TableFuncGenerator func(args ...interface{}) (TableFuncIterator, error)
+
+// CreateTableFunc registers a table-valued function, which is used like a
+// table whose arguments constrain its hidden parameter columns:
+//
+//	SELECT value FROM series(1, 10)
+//	SELECT value FROM series WHERE start = 1 AND stop = 10
+//
+// columns are the declarations of the columns of the rows returned by the
+// iterators, such as "value INTEGER", and params the names of the parameters.
+// The values of the rows are converted like the results of functions
+// registered with RegisterFunc.
+// See: https://sqlite.org/vtab.html#table_valued_functions
+func (c *SQLiteConn) CreateTableFunc(name string, columns, params []string, gen TableFuncGenerator) error {
+	return c.CreateModule(name, &tableFuncModule{columns, params, gen})
+}
+
+type tableFuncModule struct {
+	columns []string
+	params  []string
+	gen     TableFuncGenerator
+}
+
+func (m *tableFuncModule) EponymousOnly() bool {
+	return true
+}
+
+func (m *tableFuncModule) Create(c *SQLiteConn, args []string) (VTab, error) {
+	return m.Connect(c, args)
+}
+
+func (m *tableFuncModule) Connect(c *SQLiteConn, args []string) (VTab, error) {
+	decls := append([]string{}, m.columns...)
+	for _, p := range m.params {
+		decls = append(decls, p+" HIDDEN")
+	}
+	err := c.DeclareVTab(fmt.Sprintf("CREATE TABLE x(%s)", strings.Join(decls, ", ")))
+	if err != nil {
+		return nil, err
+	}
+	return &tableFuncVTab{m}, nil
+}
+
+func (m *tableFuncModule) DestroyModule() {}
+
+type tableFuncVTab struct {
+	module *tableFuncModule
+}
+
+// BestIndex passes the values of the parameters to Filter in their order.
+// IdxNum is the bitmask of the parameters that are given.
+func (v *tableFuncVTab) BestIndex(csts []InfoConstraint, ob []InfoOrderBy) (*IndexResult, error) {
+	res := &IndexResult{
+		ArgvIndex: make([]int, len(csts)),
+		Omit:      make([]bool, len(csts)),
+	}
+	ncols := len(v.module.columns)
+	given := make([]int, len(v.module.params))
+	for i := range given {
+		given[i] = -1
+	}
+	for i, cst := range csts {
+		p := cst.Column - ncols
+		if p < 0 || cst.Op != OpEQ {
+			continue
+		}
+		if !cst.Usable {
+			// Prefer the plans in which the parameter is known.
+			res.EstimatedCost += 1e12
+			continue
+		}
+		given[p] = i
+	}
+	argv := 1
+	for p, i := range given {
+		if i < 0 {
+			continue
+		}
+		res.ArgvIndex[i] = argv
+		res.Omit[i] = true
+		res.IdxNum |= 1 << uint(p)
+		argv++
+	}
+	res.EstimatedCost += 1000
+	return res, nil
+}
+
+func (v *tableFuncVTab) Disconnect() error {
+	return nil
+}
+
+func (v *tableFuncVTab) Destroy() error {
+	return nil
+}
+
+func (v *tableFuncVTab) Open() (VTabCursor, error) {
+	return &tableFuncCursor{vTab: v}, nil
+}
+
+type tableFuncCursor struct {
+	vTab  *tableFuncVTab
+	args  []interface{}
+	next  TableFuncIterator
+	row   []interface{}
+	rowid int64
+	eof   bool
+}
+
+func (vc *tableFuncCursor) Filter(idxNum int, idxStr string, vals []interface{}) error {
+	vc.args = make([]interface{}, len(vc.vTab.module.params))
+	for p := range vc.args {
+		if idxNum&(1<<uint(p)) != 0 {
+			vc.args[p], vals = vals[0], vals[1:]
+		}
+	}
+	next, err := vc.vTab.module.gen(vc.args...)
+	if err != nil {
+		return err
+	}
+	vc.next = next
+	vc.rowid = 0
+	vc.eof = false
+	return vc.Next()
+}
+
+func (vc *tableFuncCursor) Next() error {
+	row, err := vc.next()
+	if err == io.EOF {
+		vc.row, vc.eof = nil, true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(row) != len(vc.vTab.module.columns) {
+		return fmt.Errorf("expected %d values in row, got %d", len(vc.vTab.module.columns), len(row))
+	}
+	vc.row = row
+	vc.rowid++
+	return nil
+}
+
+func (vc *tableFuncCursor) EOF() bool {
+	return vc.eof
+}
+
+func (vc *tableFuncCursor) Column(c *SQLiteContext, col int) error {
+	var v interface{}
+	if ncols := len(vc.vTab.module.columns); col < ncols {
+		v = vc.row[col]
+	} else {
+		v = vc.args[col-ncols]
+	}
+	return callbackRetValue((*C.sqlite3_context)(c), v)
+}
+
+func (vc *tableFuncCursor) Rowid() (int64, error) {
+	return vc.rowid, nil
+}
+
+func (vc *tableFuncCursor) Close() error {
+	return nil
+}
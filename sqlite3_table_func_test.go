@@ -0,0 +1,95 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func series(args ...interface{}) (TableFuncIterator, error) {
+	start, ok := args[0].(int64)
+	if !ok {
+		return nil, errors.New("series needs an integer start")
+	}
+	stop := start + 2
+	if args[1] != nil {
+		stop = args[1].(int64)
+	}
+	i := start
+	return func() ([]interface{}, error) {
+		if i > stop {
+			return nil, io.EOF
+		}
+		i++
+		return []interface{}{i - 1, i%2 == 0}, nil
+	}, nil
+}
+
+func TestTableFunc(t *testing.T) {
+	sql.Register("sqlite3_TestTableFunc", &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			return conn.CreateTableFunc("series", []string{"value INTEGER", "odd INTEGER"}, []string{"start", "stop"}, series)
+		},
+	})
+	db, err := sql.Open("sqlite3_TestTableFunc", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open db: %v", err)
+	}
+	defer db.Close()
+
+	query := func(q string, args ...interface{}) [][]int64 {
+		rows, err := db.Query(q, args...)
+		if err != nil {
+			t.Fatalf("could not query %q: %v", q, err)
+		}
+		defer rows.Close()
+		cols, _ := rows.Columns()
+		var got [][]int64
+		for rows.Next() {
+			row := make([]int64, len(cols))
+			dest := make([]interface{}, len(cols))
+			for i := range row {
+				dest[i] = &row[i]
+			}
+			if err := rows.Scan(dest...); err != nil {
+				t.Fatalf("could not scan %q: %v", q, err)
+			}
+			got = append(got, row)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("could not query %q: %v", q, err)
+		}
+		return got
+	}
+
+	tests := []struct {
+		query    string
+		args     []interface{}
+		expected [][]int64
+	}{
+		{"SELECT value, odd FROM series(1, 4)", nil, [][]int64{{1, 1}, {2, 0}, {3, 1}, {4, 0}}},
+		{"SELECT value FROM series(?, ?) WHERE value > 2", []interface{}{1, 4}, [][]int64{{3}, {4}}},
+		{"SELECT value, start FROM series WHERE start = 5 AND stop IS NULL", nil, [][]int64{{5, 5}, {6, 5}, {7, 5}}},
+		{"SELECT a.value, b.value FROM series(1, 2) a, series(a.value, 2) b", nil, [][]int64{{1, 1}, {1, 2}, {2, 2}}},
+	}
+	for _, test := range tests {
+		if got := query(test.query, test.args...); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.query, test.expected, got)
+		}
+	}
+
+	var v int64
+	if err := db.QueryRow("SELECT value FROM series").Scan(&v); err == nil || err.Error() != "series needs an integer start" {
+		t.Errorf("Expected the error of the generator without start, got %v", err)
+	}
+	if _, err := db.Exec("CREATE VIRTUAL TABLE s USING series()"); err == nil {
+		t.Error("Expected an error creating a table of a table-valued function")
+	}
+}
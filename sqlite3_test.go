@@ -911,10 +911,47 @@ func TestQueryer(t *testing.T) {
 			if id != n {
 				t.Error("Failed to db.Query: not matched results")
 			}
+			n++
 		}
 	}
 }
 
+func TestQueryerSkipsStatementsWithoutRows(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// The rows are those of the first statement returning columns.
+	rows, err := db.Query(`
+	create table foo (id integer);
+	insert into foo(id) values(?);
+	insert into foo(id) values(?);
+	insert into foo(id) values(?);
+	select id from foo order by id;
+	`, 3, 2, 1)
+	if err != nil {
+		t.Fatal("Failed to call db.Query:", err)
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal("Failed to scan:", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal("Failed to iterate:", err)
+	}
+	if !reflect.DeepEqual(ids, []int{1, 2, 3}) {
+		t.Errorf("Expected the ids [1 2 3], got %v", ids)
+	}
+}
+
 func TestStress(t *testing.T) {
 	tempFilename := TempFilename(t)
 	defer os.Remove(tempFilename)
@@ -1213,6 +1250,488 @@ func TestFunctionRegistration(t *testing.T) {
 	}
 }
 
+type testValuer struct{ s string }
+
+func (v testValuer) Value() (driver.Value, error) {
+	if v.s == "" {
+		return nil, nil
+	}
+	return strings.ToUpper(v.s), nil
+}
+
+func TestFunctionRegistrationTypes(t *testing.T) {
+	orDefault := func(p *int64) int64 {
+		if p == nil {
+			return -1
+		}
+		return *p
+	}
+	double := func(p *float64) *float64 {
+		if p == nil {
+			return nil
+		}
+		d := *p * 2
+		return &d
+	}
+	nullLen := func(s sql.NullString) sql.NullInt64 {
+		return sql.NullInt64{Int64: int64(len(s.String)), Valid: s.Valid}
+	}
+	addDay := func(t time.Time) time.Time {
+		return t.AddDate(0, 0, 1)
+	}
+	pick := func(i int64) interface{} {
+		switch i {
+		case 0:
+			return nil
+		case 1:
+			return 42
+		case 2:
+			return "foo"
+		default:
+			return 1.5
+		}
+	}
+	upper := func(s string) testValuer {
+		return testValuer{s}
+	}
+
+	sql.Register("sqlite3_FunctionRegistrationTypes", &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			funcs := map[string]interface{}{
+				"or_default": orDefault,
+				"double":     double,
+				"null_len":   nullLen,
+				"add_day":    addDay,
+				"pick":       pick,
+				"upper_val":  upper,
+			}
+			for name, impl := range funcs {
+				if err := conn.RegisterFunc(name, impl, true); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+	db, err := sql.Open("sqlite3_FunctionRegistrationTypes", ":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+
+	ops := []struct {
+		query    string
+		expected interface{}
+	}{
+		{"SELECT or_default(NULL)", int64(-1)},
+		{"SELECT or_default(3)", int64(3)},
+		{"SELECT double(1.5)", float64(3)},
+		{"SELECT double(NULL) IS NULL", true},
+		{"SELECT null_len('foo')", int64(3)},
+		{"SELECT null_len(NULL) IS NULL", true},
+		{"SELECT add_day('2020-02-28 10:00:00')", "2020-02-29 10:00:00+00:00"},
+		{"SELECT add_day(0)", "1970-01-02 00:00:00+00:00"},
+		{"SELECT pick(0) IS NULL", true},
+		{"SELECT pick(1)", int64(42)},
+		{"SELECT pick(2)", "foo"},
+		{"SELECT pick(3)", float64(1.5)},
+		{"SELECT upper_val('foo')", "FOO"},
+		{"SELECT upper_val('') IS NULL", true},
+	}
+	for _, op := range ops {
+		ret := reflect.New(reflect.TypeOf(op.expected))
+		err = db.QueryRow(op.query).Scan(ret.Interface())
+		if err != nil {
+			t.Errorf("Query %q failed: %s", op.query, err)
+		} else if !reflect.DeepEqual(ret.Elem().Interface(), op.expected) {
+			t.Errorf("Query %q returned wrong value: got %v (%T), want %v (%T)", op.query, ret.Elem().Interface(), ret.Elem().Interface(), op.expected, op.expected)
+		}
+	}
+
+	var s string
+	if err := db.QueryRow("SELECT add_day('not a time')").Scan(&s); err == nil {
+		t.Error("Expected an error for an invalid time argument")
+	}
+}
+
+func TestFunctionContext(t *testing.T) {
+	var compiles int
+	var conns []*SQLiteConn
+	regex := func(ctx *SQLiteContext, re, s string) (bool, error) {
+		r, ok := ctx.GetAuxData(0).(*regexp.Regexp)
+		if !ok {
+			var err error
+			if r, err = regexp.Compile(re); err != nil {
+				return false, err
+			}
+			compiles++
+			ctx.SetAuxData(0, r)
+		}
+		return r.MatchString(s), nil
+	}
+	owner := func(ctx *SQLiteContext) bool {
+		return len(conns) == 1 && ctx.Conn() == conns[0]
+	}
+	tagged := func(ctx *SQLiteContext, args []SQLiteValue) {
+		ctx.ResultInt64(args[0].Int64())
+		ctx.ResultSubtype(42)
+	}
+	subtype := func(ctx *SQLiteContext, args []SQLiteValue) {
+		ctx.ResultInt64(int64(args[0].Subtype()))
+	}
+
+	sql.Register("sqlite3_FunctionContext", &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			conns = append(conns, conn)
+			if err := conn.RegisterFunc("regex", regex, true); err != nil {
+				return err
+			}
+			if err := conn.RegisterFunc("owner", owner, false); err != nil {
+				return err
+			}
+			if err := conn.RegisterRawFunc("tagged", 1, tagged, true); err != nil {
+				return err
+			}
+			if err := conn.RegisterRawFunc("subtype", 1, subtype, true); err != nil {
+				return err
+			}
+			return nil
+		},
+	})
+	db, err := sql.Open("sqlite3_FunctionContext", ":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	var n int
+	err = db.QueryRow(`WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c LIMIT 100)
+		SELECT count(*) FROM c WHERE regex(?, 'foo' || x)`, "^foo[0-9]$").Scan(&n)
+	if err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	if n != 9 {
+		t.Errorf("Expected 9 matches, got %d", n)
+	}
+	if compiles != 1 {
+		t.Errorf("Expected the pattern to be compiled once, got %d", compiles)
+	}
+
+	var ok bool
+	if err := db.QueryRow("SELECT owner()").Scan(&ok); err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	if !ok {
+		t.Error("Expected the context to return the connection of the function")
+	}
+
+	if err := db.QueryRow("SELECT subtype(tagged(1))").Scan(&n); err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	if n != 42 {
+		t.Errorf("Expected subtype 42, got %d", n)
+	}
+}
+
+func TestFunctionOptions(t *testing.T) {
+	if _, version, _ := Version(); version < 3031000 {
+		d := SQLiteDriver{}
+		conn, err := d.Open(":memory:")
+		if err != nil {
+			t.Fatal("Failed to open database:", err)
+		}
+		defer conn.Close()
+		err = conn.(*SQLiteConn).RegisterFuncWithOptions("f", func() int64 { return 1 }, FuncOptions{Innocuous: true})
+		if err == nil {
+			t.Error("Expected an error for an unsupported option")
+		}
+		return
+	}
+
+	one := func() int64 { return 1 }
+	rawOne := func(ctx *SQLiteContext, args []SQLiteValue) { ctx.ResultInt64(1) }
+	sql.Register("sqlite3_FunctionOptions", &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			if err := conn.RegisterFuncWithOptions("direct_one", one, FuncOptions{Deterministic: true, DirectOnly: true}); err != nil {
+				return err
+			}
+			if err := conn.RegisterFuncWithOptions("innocuous_one", one, FuncOptions{Innocuous: true, Subtype: true}); err != nil {
+				return err
+			}
+			if err := conn.RegisterRawFuncWithOptions("raw_direct_one", 0, rawOne, FuncOptions{Deterministic: true, DirectOnly: true}); err != nil {
+				return err
+			}
+			return conn.RegisterRawFuncWithOptions("raw_innocuous_one", 0, rawOne, FuncOptions{Innocuous: true, Subtype: true})
+		},
+	})
+	db, err := sql.Open("sqlite3_FunctionOptions", ":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	var n int64
+	if err := db.QueryRow("SELECT direct_one() + innocuous_one() + raw_direct_one() + raw_innocuous_one()").Scan(&n); err != nil {
+		t.Fatal("Failed to call functions directly:", err)
+	}
+	if n != 4 {
+		t.Errorf("Expected 4, got %d", n)
+	}
+
+	for _, prefix := range []string{"", "raw_"} {
+		_, err := db.Exec(fmt.Sprintf("CREATE VIEW %[1]sdirect AS SELECT %[1]sdirect_one() AS a; CREATE VIEW %[1]sinnocuous AS SELECT %[1]sinnocuous_one() AS a", prefix))
+		if err != nil {
+			t.Fatal("Failed to create views:", err)
+		}
+		if err := db.QueryRow("SELECT a FROM " + prefix + "direct").Scan(&n); err == nil {
+			t.Errorf("Expected the DirectOnly function %sdirect_one to be refused in a view", prefix)
+		}
+		if err := db.QueryRow("SELECT a FROM " + prefix + "innocuous").Scan(&n); err != nil {
+			t.Errorf("Failed to call the innocuous function %sinnocuous_one from a view: %v", prefix, err)
+		}
+	}
+}
+
+// countHandles returns the number of callback handles owned by c.
+func countHandles(c *SQLiteConn) int {
+	n := 0
+	handleVals.Range(func(_, val interface{}) bool {
+		if val.(handleVal).db == c {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+func TestConnHandles(t *testing.T) {
+	d := SQLiteDriver{}
+	var conns [2]*SQLiteConn
+	for i := range conns {
+		conn, err := d.Open(":memory:")
+		if err != nil {
+			t.Fatal("Failed to open database:", err)
+		}
+		defer conn.Close()
+		conns[i] = conn.(*SQLiteConn)
+		if err := conns[i].RegisterFunc("one", func() int64 { return 1 }, true); err != nil {
+			t.Fatal("Failed to register function:", err)
+		}
+	}
+	for _, c := range conns {
+		if n := countHandles(c); n != 1 || len(c.handles) != n {
+			t.Fatalf("Expected 1 handle in the set of the connection, got %d and %d", n, len(c.handles))
+		}
+	}
+	if err := conns[0].Close(); err != nil {
+		t.Fatal("Failed to close:", err)
+	}
+	if n := countHandles(conns[0]); n != 0 || len(conns[0].handles) != 0 {
+		t.Errorf("Expected the handles of the closed connection to be deleted, got %d and %d", n, len(conns[0].handles))
+	}
+	if n := countHandles(conns[1]); n != 1 || len(conns[1].handles) != 1 {
+		t.Errorf("Expected the handles of the other connection to be kept, got %d and %d", n, len(conns[1].handles))
+	}
+}
+
+func TestUnregisterFunc(t *testing.T) {
+	d := SQLiteDriver{}
+	conn, err := d.Open(":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer conn.Close()
+	c := conn.(*SQLiteConn)
+
+	call := func(query string) (int64, error) {
+		rows, err := c.Query(query, nil)
+		if err != nil {
+			return 0, err
+		}
+		defer rows.Close()
+		dest := make([]driver.Value, 1)
+		if err := rows.Next(dest); err != nil {
+			return 0, err
+		}
+		return dest[0].(int64), nil
+	}
+
+	handles := countHandles(c)
+	if err := c.RegisterFunc("version_of", func(x int64) int64 { return 1 }, true); err != nil {
+		t.Fatal("Failed to register function:", err)
+	}
+	if err := c.RegisterFunc("version_of", func(x int64) int64 { return 2 }, true); err != nil {
+		t.Fatal("Failed to replace function:", err)
+	}
+	if v, err := call("SELECT version_of(0)"); err != nil || v != 2 {
+		t.Fatalf("Expected the replaced function to return 2, got %d, %v", v, err)
+	}
+	if len(c.funcs) != 1 || countHandles(c) != handles+1 {
+		t.Fatalf("Expected the replaced function to be released, got %d functions and %d handles", len(c.funcs), countHandles(c)-handles)
+	}
+
+	raw := func(ctx *SQLiteContext, args []SQLiteValue) { ctx.ResultInt64(3) }
+	if err := c.RegisterRawFunc("raw_version", -1, raw, true); err != nil {
+		t.Fatal("Failed to register function:", err)
+	}
+
+	if err := c.UnregisterFunc("version_of", 1); err != nil {
+		t.Fatal("Failed to unregister function:", err)
+	}
+	if err := c.UnregisterFunc("raw_version", -1); err != nil {
+		t.Fatal("Failed to unregister function:", err)
+	}
+	if _, err := call("SELECT version_of(0)"); err == nil {
+		t.Error("Expected an error calling an unregistered function")
+	}
+	if _, err := call("SELECT raw_version()"); err == nil {
+		t.Error("Expected an error calling an unregistered function")
+	}
+	if len(c.funcs) != 0 || countHandles(c) != handles {
+		t.Fatalf("Expected unregistered functions to be released, got %d functions and %d handles", len(c.funcs), countHandles(c)-handles)
+	}
+}
+
+func TestFunctionPanic(t *testing.T) {
+	d := SQLiteDriver{}
+	conn, err := d.Open(":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer conn.Close()
+	c := conn.(*SQLiteConn)
+
+	if err := c.RegisterFunc("boom", func() int64 { panic("boom") }, false); err != nil {
+		t.Fatal("Failed to register function:", err)
+	}
+	raw := func(ctx *SQLiteContext, args []SQLiteValue) { panic(errors.New("raw boom")) }
+	if err := c.RegisterRawFunc("raw_boom", 0, raw, false); err != nil {
+		t.Fatal("Failed to register function:", err)
+	}
+
+	call := func(query string) error {
+		rows, err := c.Query(query, nil)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		return rows.Next(make([]driver.Value, 1))
+	}
+	if err := call("SELECT boom()"); err == nil || err.Error() != "panic in Go callback: boom" {
+		t.Errorf("Expected the panic to be reported as an error, got %v", err)
+	}
+
+	CallbackPanicStack = true
+	defer func() { CallbackPanicStack = false }()
+	err = call("SELECT raw_boom()")
+	if err == nil || !strings.HasPrefix(err.Error(), "panic in Go callback: raw boom\n") || !strings.Contains(err.Error(), "TestFunctionPanic") {
+		t.Errorf("Expected the panic to be reported with its stack, got %v", err)
+	}
+}
+
+func TestRawFunctionRegistration(t *testing.T) {
+	add := func(ctx *SQLiteContext, args []SQLiteValue) {
+		if args[0].Type() == ValueInteger && args[1].Type() == ValueInteger {
+			ctx.ResultInt64(args[0].Int64() + args[1].Int64())
+			return
+		}
+		ctx.ResultDouble(args[0].Float() + args[1].Float())
+	}
+	describe := func(ctx *SQLiteContext, args []SQLiteValue) {
+		var parts []string
+		for _, arg := range args {
+			switch arg.Type() {
+			case ValueInteger:
+				parts = append(parts, fmt.Sprintf("int:%d", arg.Int64()))
+			case ValueFloat:
+				parts = append(parts, fmt.Sprintf("float:%g", arg.Float()))
+			case ValueText:
+				parts = append(parts, "text:"+arg.Text())
+			case ValueBlob:
+				parts = append(parts, fmt.Sprintf("blob:%x", arg.Blob()))
+			case ValueNull:
+				parts = append(parts, "null")
+			}
+		}
+		ctx.ResultText(strings.Join(parts, ","))
+	}
+	fail := func(ctx *SQLiteContext, args []SQLiteValue) {
+		ctx.ResultError(errors.New("raw failure"))
+	}
+
+	sql.Register("sqlite3_RawFunctionRegistration", &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			if err := conn.RegisterRawFunc("raw_add", 2, add, true); err != nil {
+				return err
+			}
+			if err := conn.RegisterRawFunc("raw_describe", -1, describe, true); err != nil {
+				return err
+			}
+			if err := conn.RegisterRawFunc("raw_fail", 0, fail, false); err != nil {
+				return err
+			}
+			return nil
+		},
+	})
+	db, err := sql.Open("sqlite3_RawFunctionRegistration", ":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+
+	ops := []struct {
+		query    string
+		expected interface{}
+	}{
+		{"SELECT raw_add(1,2)", int64(3)},
+		{"SELECT raw_add(1.5,1.5)", float64(3)},
+		{"SELECT raw_describe(-1)", "int:-1"},
+		{`SELECT raw_describe(1, 2.5, 'foo', x'0102', NULL)`, "int:1,float:2.5,text:foo,blob:0102,null"},
+	}
+	for _, op := range ops {
+		ret := reflect.New(reflect.TypeOf(op.expected))
+		err = db.QueryRow(op.query).Scan(ret.Interface())
+		if err != nil {
+			t.Errorf("Query %q failed: %s", op.query, err)
+		} else if !reflect.DeepEqual(ret.Elem().Interface(), op.expected) {
+			t.Errorf("Query %q returned wrong value: got %v (%T), want %v (%T)", op.query, ret.Elem().Interface(), ret.Elem().Interface(), op.expected, op.expected)
+		}
+	}
+
+	var s string
+	err = db.QueryRow("SELECT raw_fail()").Scan(&s)
+	if err == nil || err.Error() != "raw failure" {
+		t.Errorf("Expected error %q, got %v", "raw failure", err)
+	}
+}
+
+func TestResultTextEmpty(t *testing.T) {
+	sql.Register("sqlite3_ResultTextEmpty", &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			return conn.RegisterRawFunc("raw_text", 1, func(ctx *SQLiteContext, args []SQLiteValue) {
+				ctx.ResultText(args[0].Text())
+			}, true)
+		},
+	})
+	db, err := sql.Open("sqlite3_ResultTextEmpty", ":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+
+	// An empty string is text, not NULL.
+	var typ string
+	var s sql.NullString
+	if err := db.QueryRow("SELECT typeof(raw_text('')), raw_text('')").Scan(&typ, &s); err != nil {
+		t.Fatal("Failed to query:", err)
+	}
+	if typ != "text" || !s.Valid || s.String != "" {
+		t.Errorf("Expected an empty text, got %s %+v", typ, s)
+	}
+}
+
 func TestDeclTypes(t *testing.T) {
 
 	d := SQLiteDriver{}
@@ -1248,6 +1767,79 @@ func TestDeclTypes(t *testing.T) {
 	}
 }
 
+func TestRowsRawBytes(t *testing.T) {
+	d := SQLiteDriver{}
+	conn, err := d.Open(":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer conn.Close()
+
+	rs, err := conn.(*SQLiteConn).Query("select 'foo', x'626172' union all select 'baz', x'717578' union all select 'abc', x'646566'", nil)
+	if err != nil {
+		t.Fatal("Failed to select:", err)
+	}
+	defer rs.Close()
+
+	dest := make([]driver.Value, 2)
+	var first [][]byte
+	for row, want := range [][]string{{"foo", "bar"}, {"baz", "qux"}, {"abc", "def"}} {
+		if err := rs.Next(dest); err != nil {
+			t.Fatal("Failed to get next row:", err)
+		}
+		for i := range dest {
+			var got []byte
+			if row == 0 {
+				got = dest[i].([]byte)
+			} else if raw, ok := dest[i].(sql.RawBytes); ok {
+				// The following rows keep asking for sql.RawBytes.
+				got = raw
+			} else {
+				t.Fatalf("Row %d, column %d: expected a sql.RawBytes, got %T", row, i, dest[i])
+			}
+			if string(got) != want[i] {
+				t.Errorf("Column %d: got %q, want %q", i, got, want[i])
+			}
+		}
+		if first == nil {
+			first = [][]byte{dest[0].([]byte), dest[1].([]byte)}
+			// Ask for the values of the next row without copying them.
+			dest[0], dest[1] = sql.RawBytes{}, sql.RawBytes{}
+		}
+	}
+	// Values read into sql.RawBytes must not have been written into the
+	// buffers handed out for the previous row.
+	if string(first[0]) != "foo" || string(first[1]) != "bar" {
+		t.Errorf("Buffers of the first row were modified: %q, %q", first[0], first[1])
+	}
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+	rows, err := db.Query("select 'foo', '' union all select 'bar', ''")
+	if err != nil {
+		t.Fatal("Failed to select:", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var raw sql.RawBytes
+		var b []byte
+		if err := rows.Scan(&raw, &b); err != nil {
+			t.Fatal("Failed to scan:", err)
+		}
+		if b == nil {
+			t.Error("Expected empty TEXT to scan as a non-nil []byte")
+		}
+		got = append(got, string(raw))
+	}
+	if !reflect.DeepEqual(got, []string{"foo", "bar"}) {
+		t.Errorf("Unexpected values: %q", got)
+	}
+}
+
 func TestPinger(t *testing.T) {
 	db, err := sql.Open("sqlite3", ":memory:")
 	if err != nil {
@@ -1266,7 +1858,7 @@ func TestPinger(t *testing.T) {
 
 var customFunctionOnce sync.Once
 
-func BenchmarkCustomFunctions(b *testing.B) {
+func openCustomFunctionsDB(b *testing.B) *sql.DB {
 	customFunctionOnce.Do(func() {
 		customAdd := func(a, b int64) int64 {
 			return a + b
@@ -1287,6 +1879,68 @@ func BenchmarkCustomFunctions(b *testing.B) {
 	if err != nil {
 		b.Fatal("Failed to open database:", err)
 	}
+	return db
+}
+
+func BenchmarkCustomFunctions(b *testing.B) {
+	db := openCustomFunctionsDB(b)
+	defer db.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var i int64
+		err := db.QueryRow("SELECT custom_add(1,2)").Scan(&i)
+		if err != nil {
+			b.Fatal("Failed to run custom add:", err)
+		}
+	}
+}
+
+// BenchmarkCustomFunctionsParallel calls functions from several connections
+// at once, so that it measures contention in the dispatch of callbacks.
+func BenchmarkCustomFunctionsParallel(b *testing.B) {
+	db := openCustomFunctionsDB(b)
+	defer db.Close()
+
+	// Call the function many times per query so that the time is spent
+	// dispatching callbacks rather than preparing statements.
+	const query = `WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c LIMIT 100)
+		SELECT sum(custom_add(x, 1)) FROM c`
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			var i int64
+			if err := db.QueryRow(query).Scan(&i); err != nil {
+				b.Error("Failed to run custom add:", err)
+				return
+			}
+		}
+	})
+}
+
+var rawFunctionOnce sync.Once
+
+func BenchmarkRawFunctions(b *testing.B) {
+	rawFunctionOnce.Do(func() {
+		rawAdd := func(ctx *SQLiteContext, args []SQLiteValue) {
+			ctx.ResultInt64(args[0].Int64() + args[1].Int64())
+		}
+
+		sql.Register("sqlite3_BenchmarkRawFunctions", &SQLiteDriver{
+			ConnectHook: func(conn *SQLiteConn) error {
+				// Impure function to force sqlite to reexecute it each time.
+				if err := conn.RegisterRawFunc("custom_add", 2, rawAdd, false); err != nil {
+					return err
+				}
+				return nil
+			},
+		})
+	})
+
+	db, err := sql.Open("sqlite3_BenchmarkRawFunctions", ":memory:")
+	if err != nil {
+		b.Fatal("Failed to open database:", err)
+	}
 	defer db.Close()
 
 	b.ResetTimer()
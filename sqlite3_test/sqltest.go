@@ -48,6 +48,8 @@ var benchmarks = []testing.InternalBenchmark{
 	{Name: "BenchmarkStmt", F: BenchmarkStmt},
 	{Name: "BenchmarkRows", F: BenchmarkRows},
 	{Name: "BenchmarkStmtRows", F: BenchmarkStmtRows},
+	{Name: "BenchmarkRowsRawBytes", F: BenchmarkRowsRawBytes},
+	{Name: "BenchmarkStmtRowsRawBytes", F: BenchmarkStmtRowsRawBytes},
 }
 
 // RunTests runs the SQL test suite
@@ -57,9 +59,9 @@ func RunTests(t *testing.T, d *sql.DB, dialect Dialect) {
 
 	if !testing.Short() {
 		for _, b := range benchmarks {
-			fmt.Printf("%-20s", b.Name)
+			fmt.Printf("%-26s", b.Name)
 			r := testing.Benchmark(b.F)
-			fmt.Printf("%10d %10.0f req/s\n", r.N, float64(r.N)/r.T.Seconds())
+			fmt.Printf("%10d %10.0f req/s %8d allocs/op\n", r.N, float64(r.N)/r.T.Seconds(), r.AllocsPerOp())
 		}
 	}
 	db.tearDown()
@@ -421,3 +423,58 @@ func BenchmarkStmtRows(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkRowsRawBytes is benchmark for rows scanned into sql.RawBytes
+func BenchmarkRowsRawBytes(b *testing.B) {
+	db.once.Do(makeBench)
+
+	for n := 0; n < b.N; n++ {
+		var n, s sql.RawBytes
+		var i int
+		var f float64
+		var t time.Time
+		r, err := db.Query("select * from bench")
+		if err != nil {
+			panic(err)
+		}
+		for r.Next() {
+			if err = r.Scan(&n, &i, &f, &s, &t); err != nil {
+				panic(err)
+			}
+		}
+		if err = r.Err(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// BenchmarkStmtRowsRawBytes is benchmark for statement rows scanned into
+// sql.RawBytes
+func BenchmarkStmtRowsRawBytes(b *testing.B) {
+	db.once.Do(makeBench)
+
+	st, err := db.Prepare("select * from bench")
+	if err != nil {
+		panic(err)
+	}
+	defer st.Close()
+
+	for n := 0; n < b.N; n++ {
+		var n, s sql.RawBytes
+		var i int
+		var f float64
+		var t time.Time
+		r, err := st.Query()
+		if err != nil {
+			panic(err)
+		}
+		for r.Next() {
+			if err = r.Scan(&n, &i, &f, &s, &t); err != nil {
+				panic(err)
+			}
+		}
+		if err = r.Err(); err != nil {
+			panic(err)
+		}
+	}
+}
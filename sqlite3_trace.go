@@ -109,6 +109,9 @@ func traceCallbackTrampoline(
 	// Parameter named 'X' in SQLite docs (eXtra event data?):
 	xValue unsafe.Pointer) C.int {
 
+	// Trace callbacks can't report errors; a panic only drops the event.
+	defer recoverDestroy()
+
 	if ctx == nil {
 		panic(fmt.Sprintf("No context (ev 0x%x)", traceEventCode))
 	}
@@ -257,6 +260,17 @@ func popTraceMapping(connHandle uintptr) (TraceConfig, bool) {
 //
 // See _example/go_custom_funcs for a detailed example.
 func (c *SQLiteConn) RegisterAggregator(name string, impl interface{}, pure bool) error {
+	return c.RegisterAggregatorWithOptions(name, impl, FuncOptions{Deterministic: pure})
+}
+
+// RegisterAggregatorWithOptions is like RegisterAggregator, but sets the
+// properties of the function from opts.
+func (c *SQLiteConn) RegisterAggregatorWithOptions(name string, impl interface{}, opts FuncOptions) error {
+	flags, err := opts.flags()
+	if err != nil {
+		return err
+	}
+
 	var ai aggInfo
 	ai.constructor = reflect.ValueOf(impl)
 	t := ai.constructor.Type()
@@ -348,22 +362,26 @@ func (c *SQLiteConn) RegisterAggregator(name string, impl interface{}, pure bool
 	ai.active = make(map[int64]reflect.Value)
 	ai.next = 1
 
-	// ai must outlast the database connection, or we'll have dangling pointers.
+	// ai must outlast the function in SQLite, or we'll have dangling
+	// pointers. destroyFunction removes it once SQLite lets go of it.
 	c.aggregators = append(c.aggregators, &ai)
 
 	cname := C.CString(name)
 	defer C.free(unsafe.Pointer(cname))
-	opts := C.SQLITE_UTF8
-	if pure {
-		opts |= C.SQLITE_DETERMINISTIC
-	}
-	rv := sqlite3CreateFunction(c.db, cname, C.int(stepNArgs), C.int(opts), newHandle(c, &ai), nil, C.stepTrampoline, C.doneTrampoline)
+	rv := sqlite3CreateFunction(c.db, cname, C.int(stepNArgs), flags, newHandle(c, &ai), nil, C.stepTrampoline, C.doneTrampoline)
 	if rv != C.SQLITE_OK {
 		return c.lastError()
 	}
 	return nil
 }
 
+// UnregisterAggregator removes the aggregation function registered with
+// the given name and number of arguments by RegisterAggregator, and
+// releases its Go implementation. nArg is -1 if Step is variadic.
+func (c *SQLiteConn) UnregisterAggregator(name string, nArg int) error {
+	return c.UnregisterFunc(name, nArg)
+}
+
 // SetTrace installs or removes the trace callback for the given database connection.
 // It's not named 'RegisterTrace' because only one callback can be kept and called.
 // Calling SetTrace a second time on same database connection
@@ -70,3 +70,33 @@ func TestAggregatorRegistration(t *testing.T) {
 		}
 	}
 }
+
+func TestUnregisterAggregator(t *testing.T) {
+	d := SQLiteDriver{}
+	conn, err := d.Open(":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	defer conn.Close()
+	c := conn.(*SQLiteConn)
+
+	customSum := func() *sumAggregator {
+		var ret sumAggregator
+		return &ret
+	}
+	if err := c.RegisterAggregator("customSum", customSum, true); err != nil {
+		t.Fatal("Failed to register aggregator:", err)
+	}
+	if len(c.aggregators) != 1 {
+		t.Fatalf("Expected 1 aggregator, got %d", len(c.aggregators))
+	}
+	if err := c.UnregisterAggregator("customSum", 1); err != nil {
+		t.Fatal("Failed to unregister aggregator:", err)
+	}
+	if len(c.aggregators) != 0 {
+		t.Fatalf("Expected the aggregator to be released, got %d", len(c.aggregators))
+	}
+	if _, err := c.Query("select customSum(1)", nil); err == nil {
+		t.Error("Expected an error calling an unregistered aggregator")
+	}
+}
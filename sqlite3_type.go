@@ -15,7 +15,7 @@ import (
 
 // ColumnTypeDatabaseTypeName implement RowsColumnTypeDatabaseTypeName.
 func (rc *SQLiteRows) ColumnTypeDatabaseTypeName(i int) string {
-	return C.GoString(C.sqlite3_column_decltype(rc.s.s, C.int(i)))
+	return C.GoString(C.sqlite3_column_decltype(rc.set().s.s, C.int(i)))
 }
 
 /*
@@ -35,6 +35,7 @@ func (rc *SQLiteRows) ColumnTypeNullable(i int) (nullable, ok bool) {
 
 // ColumnTypeScanType implement RowsColumnTypeScanType.
 func (rc *SQLiteRows) ColumnTypeScanType(i int) reflect.Type {
+	rc = rc.set()
 	switch C.sqlite3_column_type(rc.s.s, C.int(i)) {
 	case C.SQLITE_INTEGER:
 		switch C.GoString(C.sqlite3_column_decltype(rc.s.s, C.int(i))) {
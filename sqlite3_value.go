@@ -0,0 +1,93 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#ifndef USE_LIBSQLITE3
+#include <sqlite3-binding.h>
+#else
+#include <sqlite3.h>
+#endif
+*/
+import "C"
+
+import (
+	"unsafe"
+)
+
+// ValueType is the fundamental datatype of an SQLite value.
+// See: https://www.sqlite.org/c3ref/c_blob.html
+type ValueType int
+
+// Fundamental datatypes returned by SQLiteValue.Type.
+const (
+	ValueInteger ValueType = C.SQLITE_INTEGER
+	ValueFloat   ValueType = C.SQLITE_FLOAT
+	ValueText    ValueType = C.SQLITE_TEXT
+	ValueBlob    ValueType = C.SQLITE_BLOB
+	ValueNull    ValueType = C.SQLITE_NULL
+)
+
+// SQLiteValue is an argument of an SQL function registered with
+// RegisterRawFunc. It behaves like sqlite3_value and is only valid during
+// the call it was passed to.
+//
+// The accessors convert the value the way SQLite does when it doesn't have
+// the requested type.
+// See: https://www.sqlite.org/c3ref/value_blob.html
+type SQLiteValue struct {
+	// SQLiteValue must stay the size of a pointer: the arguments are given
+	// to Go functions as a slice over the argv array of SQLite.
+	v *C.sqlite3_value
+}
+
+// Type returns the datatype of the value.
+func (v SQLiteValue) Type() ValueType {
+	return ValueType(C.sqlite3_value_type(v.v))
+}
+
+// Int64 returns the value as an integer.
+func (v SQLiteValue) Int64() int64 {
+	return int64(C.sqlite3_value_int64(v.v))
+}
+
+// Float returns the value as a floating point number.
+func (v SQLiteValue) Float() float64 {
+	return float64(C.sqlite3_value_double(v.v))
+}
+
+// Text returns a copy of the value as a string.
+func (v SQLiteValue) Text() string {
+	p := C.sqlite3_value_text(v.v)
+	n := C.sqlite3_value_bytes(v.v)
+	return C.GoStringN((*C.char)(unsafe.Pointer(p)), n)
+}
+
+// Blob returns a copy of the value as a byte slice. It returns nil for an
+// empty BLOB or NULL.
+func (v SQLiteValue) Blob() []byte {
+	p := C.sqlite3_value_blob(v.v)
+	n := C.sqlite3_value_bytes(v.v)
+	if p == nil || n == 0 {
+		return nil
+	}
+	return C.GoBytes(p, n)
+}
+
+// Pointer returns the value of a Pointer bound to a statement or returned
+// by a function, and whether v holds one. The Type of such values is
+// ValueNull.
+func (v SQLiteValue) Pointer() (interface{}, bool) {
+	p, ok := valuePointer(v.v)
+	return p.Value, ok
+}
+
+// Subtype returns the subtype of the value, set by the function that
+// returned it, or 0.
+// See: https://www.sqlite.org/c3ref/value_subtype.html
+func (v SQLiteValue) Subtype() uint {
+	return uint(C.sqlite3_value_subtype(v.v))
+}
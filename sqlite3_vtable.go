@@ -2,18 +2,10 @@
 //
 // Use of this source code is governed by an MIT-style
 // license that can be found in the LICENSE file.
-// +build vtable
 
 package sqlite3
 
 /*
-#cgo CFLAGS: -std=gnu99
-#cgo CFLAGS: -DSQLITE_ENABLE_RTREE -DSQLITE_THREADSAFE
-#cgo CFLAGS: -DSQLITE_ENABLE_FTS3 -DSQLITE_ENABLE_FTS3_PARENTHESIS -DSQLITE_ENABLE_FTS4_UNICODE61
-#cgo CFLAGS: -DSQLITE_TRACE_SIZE_LIMIT=15
-#cgo CFLAGS: -DSQLITE_ENABLE_COLUMN_METADATA=1
-#cgo CFLAGS: -Wno-deprecated-declarations
-
 #ifndef USE_LIBSQLITE3
 #include <sqlite3-binding.h>
 #else
@@ -27,6 +19,56 @@ static inline char *_sqlite3_mprintf(char *zFormat, char *arg) {
   return sqlite3_mprintf(zFormat, arg);
 }
 
+// The planning interfaces of sqlite3_index_info are missing from older
+// versions, which then behave as if the information wasn't available.
+static const char *_sqlite3_vtab_collation(sqlite3_index_info *info, int i) {
+#if SQLITE_VERSION_NUMBER >= 3022000
+	return sqlite3_vtab_collation(info, i);
+#else
+	return "BINARY";
+#endif
+}
+
+static int _sqlite3_vtab_rhs_value(sqlite3_index_info *info, int i, sqlite3_value **ppVal) {
+#if SQLITE_VERSION_NUMBER >= 3038000
+	return sqlite3_vtab_rhs_value(info, i, ppVal);
+#else
+	return SQLITE_NOTFOUND;
+#endif
+}
+
+static int _sqlite3_vtab_in(sqlite3_index_info *info, int i, int bHandle) {
+#if SQLITE_VERSION_NUMBER >= 3038000
+	return sqlite3_vtab_in(info, i, bHandle);
+#else
+	return 0;
+#endif
+}
+
+static int _sqlite3_vtab_distinct(sqlite3_index_info *info) {
+#if SQLITE_VERSION_NUMBER >= 3038000
+	return sqlite3_vtab_distinct(info);
+#else
+	return 0;
+#endif
+}
+
+static int _sqlite3_vtab_in_first(sqlite3_value *pVal, sqlite3_value **ppOut) {
+#if SQLITE_VERSION_NUMBER >= 3038000
+	return sqlite3_vtab_in_first(pVal, ppOut);
+#else
+	return SQLITE_ERROR;
+#endif
+}
+
+static int _sqlite3_vtab_in_next(sqlite3_value *pVal, sqlite3_value **ppOut) {
+#if SQLITE_VERSION_NUMBER >= 3038000
+	return sqlite3_vtab_in_next(pVal, ppOut);
+#else
+	return SQLITE_DONE;
+#endif
+}
+
 typedef struct goVTab goVTab;
 
 struct goVTab {
@@ -76,18 +118,21 @@ static inline int cXBestIndex(sqlite3_vtab *pVTab, sqlite3_index_info *info) {
 
 char* goVRelease(void *pVTab, int isDestroy);
 
+// SQLite keeps the table when xDestroy fails, and disconnects it later, but
+// frees it whatever xDisconnect returns.
 static int cXRelease(sqlite3_vtab *pVTab, int isDestroy) {
 	char *pzErr = goVRelease(((goVTab*)pVTab)->vTab, isDestroy);
-	if (pzErr) {
+	if (pzErr && isDestroy) {
 		if (pVTab->zErrMsg)
 			sqlite3_free(pVTab->zErrMsg);
 		pVTab->zErrMsg = pzErr;
 		return SQLITE_ERROR;
 	}
+	sqlite3_free(pzErr);
 	if (pVTab->zErrMsg)
 		sqlite3_free(pVTab->zErrMsg);
 	sqlite3_free(pVTab);
-	return SQLITE_OK;
+	return pzErr ? SQLITE_ERROR : SQLITE_OK;
 }
 
 static inline int cXDisconnect(sqlite3_vtab *pVTab) {
@@ -104,10 +149,23 @@ struct goVTabCursor {
 	void *vTabCursor;
 };
 
+static int setVTabErrMsg(sqlite3_vtab *pVTab, char *pzErr) {
+	if (!pzErr)
+		return SQLITE_OK;
+	if (pVTab->zErrMsg)
+		sqlite3_free(pVTab->zErrMsg);
+	pVTab->zErrMsg = pzErr;
+	return SQLITE_ERROR;
+}
+
 uintptr_t goVOpen(void *pVTab, char **pzErr);
 
 static int cXOpen(sqlite3_vtab *pVTab, sqlite3_vtab_cursor **ppCursor) {
-	void *vTabCursor = (void *)goVOpen(((goVTab*)pVTab)->vTab, &(pVTab->zErrMsg));
+	char *pzErr = 0;
+	void *vTabCursor = (void *)goVOpen(((goVTab*)pVTab)->vTab, &pzErr);
+	if (pzErr) {
+		return setVTabErrMsg(pVTab, pzErr);
+	}
 	goVTabCursor *pCursor = (goVTabCursor *)sqlite3_malloc(sizeof(goVTabCursor));
 	if (!pCursor) {
 		return SQLITE_NOMEM;
@@ -128,12 +186,14 @@ static int setErrMsg(sqlite3_vtab_cursor *pCursor, char *pzErr) {
 char* goVClose(void *pCursor);
 
 static int cXClose(sqlite3_vtab_cursor *pCursor) {
+	// SQLite doesn't close the cursor again if xClose fails.
+	int rc = SQLITE_OK;
 	char *pzErr = goVClose(((goVTabCursor*)pCursor)->vTabCursor);
 	if (pzErr) {
-		return setErrMsg(pCursor, pzErr);
+		rc = setErrMsg(pCursor, pzErr);
 	}
 	sqlite3_free(pCursor);
-	return SQLITE_OK;
+	return rc;
 }
 
 char* goVFilter(void *pCursor, int idxNum, char* idxName, int argc, sqlite3_value **argv);
@@ -195,37 +255,152 @@ static int cXUpdate(sqlite3_vtab *pVTab, int argc, sqlite3_value **argv, sqlite3
 	return SQLITE_OK;
 }
 
-static sqlite3_module goModule = {
-	0,                       // iVersion
-	cXCreate,                // xCreate - create a table
-	cXConnect,               // xConnect - connect to an existing table
-	cXBestIndex,             // xBestIndex - Determine search strategy
-	cXDisconnect,            // xDisconnect - Disconnect from a table
-	cXDestroy,               // xDestroy - Drop a table
-	cXOpen,                  // xOpen - open a cursor
-	cXClose,                 // xClose - close a cursor
-	cXFilter,                // xFilter - configure scan constraints
-	cXNext,                  // xNext - advance a cursor
-	cXEof,                   // xEof
-	cXColumn,                // xColumn - read data
-	cXRowid,                 // xRowid - read data
-	cXUpdate,                // xUpdate - write data
-// Not implemented
-	0,                       // xBegin - begin transaction
-	0,                       // xSync - sync transaction
-	0,                       // xCommit - commit transaction
-	0,                       // xRollback - rollback transaction
-	0,                       // xFindFunction - function overloading
-	0,                       // xRename - rename the table
-	0,                       // xSavepoint
-	0,                       // xRelease
-	0	                     // xRollbackTo
+char* goVBegin(void *pVTab);
+char* goVSync(void *pVTab);
+char* goVCommit(void *pVTab);
+char* goVRollback(void *pVTab);
+
+static int cXBegin(sqlite3_vtab *pVTab) {
+	return setVTabErrMsg(pVTab, goVBegin(((goVTab*)pVTab)->vTab));
+}
+static int cXSync(sqlite3_vtab *pVTab) {
+	return setVTabErrMsg(pVTab, goVSync(((goVTab*)pVTab)->vTab));
+}
+static int cXCommit(sqlite3_vtab *pVTab) {
+	return setVTabErrMsg(pVTab, goVCommit(((goVTab*)pVTab)->vTab));
+}
+static int cXRollback(sqlite3_vtab *pVTab) {
+	return setVTabErrMsg(pVTab, goVRollback(((goVTab*)pVTab)->vTab));
+}
+
+char* goVSavepoint(void *pVTab, int i);
+char* goVReleaseSavepoint(void *pVTab, int i);
+char* goVRollbackTo(void *pVTab, int i);
+
+static int cXSavepoint(sqlite3_vtab *pVTab, int i) {
+	return setVTabErrMsg(pVTab, goVSavepoint(((goVTab*)pVTab)->vTab, i));
+}
+static int cXReleaseSavepoint(sqlite3_vtab *pVTab, int i) {
+	return setVTabErrMsg(pVTab, goVReleaseSavepoint(((goVTab*)pVTab)->vTab, i));
+}
+static int cXRollbackTo(sqlite3_vtab *pVTab, int i) {
+	return setVTabErrMsg(pVTab, goVRollbackTo(((goVTab*)pVTab)->vTab, i));
+}
+
+char* goVRename(void *pVTab, char *zNew);
+
+static int cXRename(sqlite3_vtab *pVTab, const char *zNew) {
+	return setVTabErrMsg(pVTab, goVRename(((goVTab*)pVTab)->vTab, (char*)zNew));
+}
+
+int goVFindFunction(void *pVTab, int nArg, char *zName, void **pxFunc, uintptr_t *pArg);
+
+static int cXFindFunction(sqlite3_vtab *pVTab, int nArg, const char *zName, void (**pxFunc)(sqlite3_context*,int,sqlite3_value**), void **ppArg) {
+	void *xFunc = 0;
+	uintptr_t arg = 0;
+	int rv = goVFindFunction(((goVTab*)pVTab)->vTab, nArg, (char*)zName, &xFunc, &arg);
+	if (rv) {
+		*pxFunc = (void (*)(sqlite3_context*,int,sqlite3_value**))xFunc;
+		*ppArg = (void*)arg;
+	}
+	return rv;
+}
+
+// The members of sqlite3_module, in order: iVersion, xCreate, xConnect,
+// xBestIndex, xDisconnect, xDestroy, xOpen, xClose, xFilter, xNext, xEof,
+// xColumn, xRowid, xUpdate, xBegin, xSync, xCommit, xRollback,
+// xFindFunction, xRename, xSavepoint, xRelease, xRollbackTo and xShadowName.
+//
+// The modules differ in xCreate, which is the same as xConnect for
+// eponymous modules and missing for eponymous-only ones, and in
+// xShadowName, which only exists in version 3 of sqlite3_module.
+#if SQLITE_VERSION_NUMBER >= 3026000
+# define GO_MODULE_VERSION 3
+# define GO_MODULE_SHADOWNAME(xShadowName) , xShadowName
+#else
+# define GO_MODULE_VERSION 2
+# define GO_MODULE_SHADOWNAME(xShadowName)
+#endif
+#define GO_MODULE(xCreate, xShadowName) { \
+	GO_MODULE_VERSION, \
+	xCreate, \
+	cXConnect, \
+	cXBestIndex, \
+	cXDisconnect, \
+	cXDestroy, \
+	cXOpen, \
+	cXClose, \
+	cXFilter, \
+	cXNext, \
+	cXEof, \
+	cXColumn, \
+	cXRowid, \
+	cXUpdate, \
+	cXBegin, \
+	cXSync, \
+	cXCommit, \
+	cXRollback, \
+	cXFindFunction, \
+	cXRename, \
+	cXSavepoint, \
+	cXReleaseSavepoint, \
+	cXRollbackTo \
+	GO_MODULE_SHADOWNAME(xShadowName) \
+}
+#define GO_MODULES(xShadowName) { \
+	GO_MODULE(cXCreate, xShadowName), \
+	GO_MODULE(cXConnect, xShadowName), \
+	GO_MODULE(0, xShadowName), \
+}
+
+static sqlite3_module goModules[3] = GO_MODULES(0);
+
+// xShadowName doesn't tell which module it is called for, so each
+// ShadowNamer uses the modules of a slot, whose xShadowName passes the slot
+// to Go.
+#if SQLITE_VERSION_NUMBER >= 3026000
+# define GO_SHADOWNAME_SLOTS 64
+
+int goMShadowName(int slot, char *zName);
+
+#define GO_SHADOWNAME(i, slot) \
+static int cXShadowName##i(const char *zName) { \
+	return goMShadowName(slot, (char*)zName); \
+} \
+static sqlite3_module goShadowModules##i[3] = GO_MODULES(cXShadowName##i);
+#define GO_SHADOWNAMES(a) \
+	GO_SHADOWNAME(a##0, a*8) GO_SHADOWNAME(a##1, a*8+1) \
+	GO_SHADOWNAME(a##2, a*8+2) GO_SHADOWNAME(a##3, a*8+3) \
+	GO_SHADOWNAME(a##4, a*8+4) GO_SHADOWNAME(a##5, a*8+5) \
+	GO_SHADOWNAME(a##6, a*8+6) GO_SHADOWNAME(a##7, a*8+7)
+#define GO_SHADOWMODULES(a) \
+	goShadowModules##a##0, goShadowModules##a##1, \
+	goShadowModules##a##2, goShadowModules##a##3, \
+	goShadowModules##a##4, goShadowModules##a##5, \
+	goShadowModules##a##6, goShadowModules##a##7,
+
+GO_SHADOWNAMES(0) GO_SHADOWNAMES(1) GO_SHADOWNAMES(2) GO_SHADOWNAMES(3)
+GO_SHADOWNAMES(4) GO_SHADOWNAMES(5) GO_SHADOWNAMES(6) GO_SHADOWNAMES(7)
+
+static sqlite3_module *goShadowModules[GO_SHADOWNAME_SLOTS] = {
+	GO_SHADOWMODULES(0) GO_SHADOWMODULES(1) GO_SHADOWMODULES(2) GO_SHADOWMODULES(3)
+	GO_SHADOWMODULES(4) GO_SHADOWMODULES(5) GO_SHADOWMODULES(6) GO_SHADOWMODULES(7)
 };
+#else
+# define GO_SHADOWNAME_SLOTS 0
+#endif
 
 void goMDestroy(void*);
 
-static int _sqlite3_create_module(sqlite3 *db, const char *zName, uintptr_t pClientData) {
-  return sqlite3_create_module_v2(db, zName, &goModule, (void*) pClientData, goMDestroy);
+// eponymous is 0 for regular modules, 1 for eponymous ones, and 2 for
+// eponymous-only ones. slot is the ShadowNamer slot, or -1.
+static int _sqlite3_create_module(sqlite3 *db, const char *zName, uintptr_t pClientData, int eponymous, int slot) {
+  sqlite3_module *modules = goModules;
+#if SQLITE_VERSION_NUMBER >= 3026000
+  if (slot >= 0)
+    modules = goShadowModules[slot];
+#endif
+  return sqlite3_create_module_v2(db, zName, &modules[eponymous], (void*) pClientData, goMDestroy);
 }
 */
 import "C"
@@ -234,6 +409,7 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"sync"
 	"unsafe"
 )
 
@@ -241,16 +417,25 @@ type sqliteModule struct {
 	c      *SQLiteConn
 	name   string
 	module Module
+	slot   int // the ShadowNamer slot, or -1
 }
 
 type sqliteVTab struct {
 	module *sqliteModule
 	vTab   VTab
+
+	// funcs holds the handles of the functions returned by FindFunction,
+	// by name and number of arguments, or 0 for those it didn't overload.
+	funcs map[string]uintptr
 }
 
 type sqliteVTabCursor struct {
 	vTab       *sqliteVTab
 	vTabCursor VTabCursor
+
+	// err is the panic recovered in EOF, which can't return errors. It is
+	// reported by the next callback of the cursor instead.
+	err error
 }
 
 // Op is type of operations.
@@ -267,14 +452,54 @@ const (
 	OpLIKE          = 65 /* 3.10.0 and later only */
 	OpGLOB          = 66 /* 3.10.0 and later only */
 	OpREGEXP        = 67 /* 3.10.0 and later only */
+	OpNE            = 68 /* 3.21.0 and later only */
+	OpISNOT         = 69 /* 3.21.0 and later only */
+	OpISNOTNULL     = 70 /* 3.21.0 and later only */
+	OpISNULL        = 71 /* 3.21.0 and later only */
+	OpIS            = 72 /* 3.21.0 and later only */
+	OpLIMIT         = 73 /* 3.38.0 and later only */
+	OpOFFSET        = 74 /* 3.38.0 and later only */
 	OpScanUnique    = 1  /* Scan visits at most 1 row */
 )
 
 // InfoConstraint give information of constraint.
+//
+// The constraints of OpLIMIT and OpOFFSET have no column, and their value
+// is the number of rows.
 type InfoConstraint struct {
 	Column int
 	Op     Op
 	Usable bool
+	// Collation is the name of the collating sequence that compares the
+	// column to the value, BINARY before 3.22.0.
+	Collation string
+	// RHS is the right-hand value of the constraint when HasRHS is set,
+	// which SQLite only does when it is known while planning, such as a
+	// literal. 3.38.0 and later only.
+	RHS    interface{}
+	HasRHS bool
+	// In is set for the constraints of IN operators that can be processed
+	// all at once. 3.38.0 and later only.
+	// See: https://sqlite.org/c3ref/vtab_in.html
+	In bool
+}
+
+// IndexInfo holds all the inputs of xBestIndex.
+// See: https://www.sqlite.org/c3ref/index_info.html
+type IndexInfo struct {
+	Constraints []InfoConstraint
+	OrderBy     []InfoOrderBy
+	// ColUsed is the mask of the columns that the statement uses. The last
+	// bit stands for all the columns after the 63th.
+	ColUsed uint64
+	// Distinct tells how strictly the rows must follow OrderBy, as
+	// returned by sqlite3_vtab_distinct: 0 when they must all be returned
+	// in order, 1 when only rows of the same group must come together, 2
+	// when the duplicates of a DISTINCT query can be skipped in any order,
+	// and 3 when they can be skipped but the rows must be ordered. It is
+	// always 0 before 3.38.0.
+	// See: https://sqlite.org/c3ref/vtab_distinct.html
+	Distinct int
 }
 
 // InfoOrderBy give information of order-by.
@@ -283,23 +508,34 @@ type InfoOrderBy struct {
 	Desc   bool
 }
 
-func constraints(info *C.sqlite3_index_info) []InfoConstraint {
+func constraints(info *C.sqlite3_index_info) ([]InfoConstraint, error) {
 	l := info.nConstraint
 	slice := (*[1 << 30]C.struct_sqlite3_index_constraint)(unsafe.Pointer(info.aConstraint))[:l:l]
 
 	cst := make([]InfoConstraint, 0, l)
-	for _, c := range slice {
+	for i, c := range slice {
 		var usable bool
 		if c.usable > 0 {
 			usable = true
 		}
-		cst = append(cst, InfoConstraint{
-			Column: int(c.iColumn),
-			Op:     Op(c.op),
-			Usable: usable,
-		})
+		ic := InfoConstraint{
+			Column:    int(c.iColumn),
+			Op:        Op(c.op),
+			Usable:    usable,
+			Collation: C.GoString(C._sqlite3_vtab_collation(info, C.int(i))),
+			In:        C._sqlite3_vtab_in(info, C.int(i), -1) != 0,
+		}
+		var rhs *C.sqlite3_value
+		if C._sqlite3_vtab_rhs_value(info, C.int(i), &rhs) == C.SQLITE_OK {
+			v, err := callbackArgGeneric(rhs)
+			if err != nil {
+				return nil, err
+			}
+			ic.RHS, ic.HasRHS = v.Interface(), true
+		}
+		cst = append(cst, ic)
 	}
-	return cst
+	return cst, nil
 }
 
 func orderBys(info *C.sqlite3_index_info) []InfoOrderBy {
@@ -323,8 +559,29 @@ func orderBys(info *C.sqlite3_index_info) []InfoOrderBy {
 // IndexResult is a Go struct representation of what eventually ends up in the
 // output fields for `sqlite3_index_info`
 // See: https://www.sqlite.org/c3ref/index_info.html
+//
+// The constraints whose values are passed to VTabCursor.Filter are chosen
+// either with Used or with ArgvIndex, which both have one entry per
+// constraint given to BestIndex. With Used, the values of the used
+// constraints are passed in the order of the constraints. ArgvIndex gives
+// instead the position of the value of each constraint in the vals slice of
+// Filter, counting from 1, or 0 for the constraints that aren't passed; the
+// positions must go from 1 to len(vals) with no gaps.
+//
+// Omit tells SQLite which of the passed constraints it can skip checking
+// again, because the cursor only returns rows that satisfy them. When it is
+// nil, all the constraints marked in Used are omitted, and none of those
+// given by ArgvIndex.
+//
+// In asks for the whole list of values of the IN constraints it marks,
+// instead of calling Filter once for each value. It only applies to the
+// constraints whose value is passed to Filter, which then receives it as a
+// []interface{} of the distinct values of the list.
 type IndexResult struct {
 	Used           []bool // aConstraintUsage
+	ArgvIndex      []int  // aConstraintUsage[].argvIndex, overrides Used
+	Omit           []bool // aConstraintUsage[].omit
+	In             []bool // sqlite3_vtab_in, for the constraints with In set
 	IdxNum         int
 	IdxStr         string
 	AlreadyOrdered bool // orderByConsumed
@@ -341,18 +598,25 @@ func mPrintf(format, arg string) *C.char {
 	return C._sqlite3_mprintf(cf, ca)
 }
 
+// recoverVTab must be deferred by the callbacks of virtual tables. Panics
+// must not unwind through the C frames of SQLite, so it recovers them and
+// makes them the error message returned in *zErr.
+func recoverVTab(zErr **C.char) {
+	if r := recover(); r != nil {
+		*zErr = mPrintf("%s", callbackPanicError(r).Error())
+	}
+}
+
 //export goMInit
-func goMInit(db, pClientData unsafe.Pointer, argc C.int, argv **C.char, pzErr **C.char, isCreate C.int) C.uintptr_t {
+func goMInit(db, pClientData unsafe.Pointer, argc C.int, argv **C.char, pzErr **C.char, isCreate C.int) (ret C.uintptr_t) {
+	defer recoverVTab(pzErr)
 	m := lookupHandle(uintptr(pClientData)).(*sqliteModule)
 	if m.c.db != (*C.sqlite3)(db) {
 		*pzErr = mPrintf("%s", "Inconsistent db handles")
 		return 0
 	}
 	args := make([]string, argc)
-	var A []*C.char
-	slice := reflect.SliceHeader{Data: uintptr(unsafe.Pointer(argv)), Len: int(argc), Cap: int(argc)}
-	a := reflect.NewAt(reflect.TypeOf(A), unsafe.Pointer(&slice)).Elem().Interface()
-	for i, s := range a.([]*C.char) {
+	for i, s := range (*[(math.MaxInt32 - 1) / unsafe.Sizeof((*C.char)(nil))]*C.char)(unsafe.Pointer(argv))[:argc:argc] {
 		args[i] = C.GoString(s)
 	}
 	var vTab VTab
@@ -367,62 +631,110 @@ func goMInit(db, pClientData unsafe.Pointer, argc C.int, argv **C.char, pzErr **
 		*pzErr = mPrintf("%s", err.Error())
 		return 0
 	}
-	vt := sqliteVTab{m, vTab}
+	vt := sqliteVTab{module: m, vTab: vTab}
 	*pzErr = nil
 	return C.uintptr_t(newHandle(m.c, &vt))
 }
 
 //export goVRelease
-func goVRelease(pVTab unsafe.Pointer, isDestroy C.int) *C.char {
+func goVRelease(pVTab unsafe.Pointer, isDestroy C.int) (zErr *C.char) {
+	defer recoverVTab(&zErr)
 	vt := lookupHandle(uintptr(pVTab)).(*sqliteVTab)
-	var err error
 	if isDestroy == 1 {
-		err = vt.vTab.Destroy()
-	} else {
-		err = vt.vTab.Disconnect()
+		// The table is disconnected later if Destroy fails.
+		if err := vt.vTab.Destroy(); err != nil {
+			return mPrintf("%s", err.Error())
+		}
+		vt.release(uintptr(pVTab))
+		return nil
 	}
-	if err != nil {
+	// The table is gone even if Disconnect fails or panics.
+	defer vt.release(uintptr(pVTab))
+	if err := vt.vTab.Disconnect(); err != nil {
 		return mPrintf("%s", err.Error())
 	}
 	return nil
 }
 
+// release deletes the handle of the table and those of its functions.
+func (vt *sqliteVTab) release(h uintptr) {
+	for _, f := range vt.funcs {
+		deleteHandle(f)
+	}
+	deleteHandle(h)
+}
+
 //export goVOpen
-func goVOpen(pVTab unsafe.Pointer, pzErr **C.char) C.uintptr_t {
+func goVOpen(pVTab unsafe.Pointer, pzErr **C.char) (ret C.uintptr_t) {
+	defer recoverVTab(pzErr)
 	vt := lookupHandle(uintptr(pVTab)).(*sqliteVTab)
 	vTabCursor, err := vt.vTab.Open()
 	if err != nil {
 		*pzErr = mPrintf("%s", err.Error())
 		return 0
 	}
-	vtc := sqliteVTabCursor{vt, vTabCursor}
+	vtc := sqliteVTabCursor{vTab: vt, vTabCursor: vTabCursor}
 	*pzErr = nil
 	return C.uintptr_t(newHandle(vt.module.c, &vtc))
 }
 
 //export goVBestIndex
-func goVBestIndex(pVTab unsafe.Pointer, icp unsafe.Pointer) *C.char {
+func goVBestIndex(pVTab unsafe.Pointer, icp unsafe.Pointer) (zErr *C.char) {
+	defer recoverVTab(&zErr)
 	vt := lookupHandle(uintptr(pVTab)).(*sqliteVTab)
 	info := (*C.sqlite3_index_info)(icp)
-	csts := constraints(info)
-	res, err := vt.vTab.BestIndex(csts, orderBys(info))
+	csts, err := constraints(info)
 	if err != nil {
 		return mPrintf("%s", err.Error())
 	}
-	if len(res.Used) != len(csts) {
+	var res *IndexResult
+	if p, ok := vt.vTab.(VTabPlanner); ok {
+		res, err = p.BestIndexInfo(&IndexInfo{
+			Constraints: csts,
+			OrderBy:     orderBys(info),
+			ColUsed:     uint64(info.colUsed),
+			Distinct:    int(C._sqlite3_vtab_distinct(info)),
+		})
+	} else {
+		res, err = vt.vTab.BestIndex(csts, orderBys(info))
+	}
+	if err != nil {
+		return mPrintf("%s", err.Error())
+	}
+	if res.ArgvIndex == nil && len(res.Used) != len(csts) {
 		return mPrintf("Result.Used != expected value", "")
 	}
+	if res.ArgvIndex != nil && len(res.ArgvIndex) != len(csts) {
+		return mPrintf("Result.ArgvIndex != expected value", "")
+	}
+	if res.Omit != nil && len(res.Omit) != len(csts) {
+		return mPrintf("Result.Omit != expected value", "")
+	}
+	if res.In != nil && len(res.In) != len(csts) {
+		return mPrintf("Result.In != expected value", "")
+	}
 
 	// Get a pointer to constraint_usage struct so we can update in place.
 	l := info.nConstraint
 	s := (*[1 << 30]C.struct_sqlite3_index_constraint_usage)(unsafe.Pointer(info.aConstraintUsage))[:l:l]
 	index := 1
 	for i := C.int(0); i < info.nConstraint; i++ {
-		if res.Used[i] {
+		if res.ArgvIndex != nil {
+			s[i].argvIndex = C.int(res.ArgvIndex[i])
+			if res.Omit != nil && res.Omit[i] {
+				s[i].omit = C.uchar(1)
+			}
+		} else if res.Used[i] {
 			s[i].argvIndex = C.int(index)
 			s[i].omit = C.uchar(1)
+			if res.Omit != nil && !res.Omit[i] {
+				s[i].omit = C.uchar(0)
+			}
 			index++
 		}
+		if res.In != nil && res.In[i] && csts[i].In {
+			C._sqlite3_vtab_in(info, i, 1)
+		}
 	}
 
 	info.idxNum = C.int(res.IdxNum)
@@ -440,8 +752,11 @@ func goVBestIndex(pVTab unsafe.Pointer, icp unsafe.Pointer) *C.char {
 }
 
 //export goVClose
-func goVClose(pCursor unsafe.Pointer) *C.char {
+func goVClose(pCursor unsafe.Pointer) (zErr *C.char) {
+	defer recoverVTab(&zErr)
 	vtc := lookupHandle(uintptr(pCursor)).(*sqliteVTabCursor)
+	// The cursor is freed even if Close fails.
+	deleteHandle(uintptr(pCursor))
 	err := vtc.vTabCursor.Close()
 	if err != nil {
 		return mPrintf("%s", err.Error())
@@ -451,16 +766,32 @@ func goVClose(pCursor unsafe.Pointer) *C.char {
 
 //export goMDestroy
 func goMDestroy(pClientData unsafe.Pointer) {
+	defer recoverDestroy()
 	m := lookupHandle(uintptr(pClientData)).(*sqliteModule)
+	if m.slot >= 0 {
+		releaseShadowSlot(m.slot)
+	}
 	m.module.DestroyModule()
 }
 
 //export goVFilter
-func goVFilter(pCursor unsafe.Pointer, idxNum C.int, idxName *C.char, argc C.int, argv **C.sqlite3_value) *C.char {
+func goVFilter(pCursor unsafe.Pointer, idxNum C.int, idxName *C.char, argc C.int, argv **C.sqlite3_value) (zErr *C.char) {
+	defer recoverVTab(&zErr)
 	vtc := lookupHandle(uintptr(pCursor)).(*sqliteVTabCursor)
+	vtc.err = nil
 	args := (*[(math.MaxInt32 - 1) / unsafe.Sizeof((*C.sqlite3_value)(nil))]*C.sqlite3_value)(unsafe.Pointer(argv))[:argc:argc]
 	vals := make([]interface{}, 0, argc)
 	for _, v := range args {
+		if C.sqlite3_value_type(v) == C.SQLITE_NULL {
+			list, ok, err := inValues(v)
+			if err != nil {
+				return mPrintf("%s", err.Error())
+			}
+			if ok {
+				vals = append(vals, list)
+				continue
+			}
+		}
 		conv, err := callbackArgGeneric(v)
 		if err != nil {
 			return mPrintf("%s", err.Error())
@@ -474,9 +805,35 @@ func goVFilter(pCursor unsafe.Pointer, idxNum C.int, idxName *C.char, argc C.int
 	return nil
 }
 
+// inValues returns the values of the list of an IN constraint that
+// BestIndex asked to process all at once, or false if v isn't one.
+func inValues(v *C.sqlite3_value) ([]interface{}, bool, error) {
+	var x *C.sqlite3_value
+	rv := C._sqlite3_vtab_in_first(v, &x)
+	if rv == C.SQLITE_ERROR || rv == C.SQLITE_MISUSE {
+		return nil, false, nil
+	}
+	list := []interface{}{}
+	for ; rv == C.SQLITE_OK; rv = C._sqlite3_vtab_in_next(v, &x) {
+		conv, err := callbackArgGeneric(x)
+		if err != nil {
+			return nil, false, err
+		}
+		list = append(list, conv.Interface())
+	}
+	if rv != C.SQLITE_DONE {
+		return nil, false, Error{Code: ErrNo(rv)}
+	}
+	return list, true, nil
+}
+
 //export goVNext
-func goVNext(pCursor unsafe.Pointer) *C.char {
+func goVNext(pCursor unsafe.Pointer) (zErr *C.char) {
+	defer recoverVTab(&zErr)
 	vtc := lookupHandle(uintptr(pCursor)).(*sqliteVTabCursor)
+	if vtc.err != nil {
+		return mPrintf("%s", vtc.err.Error())
+	}
 	err := vtc.vTabCursor.Next()
 	if err != nil {
 		return mPrintf("%s", err.Error())
@@ -485,8 +842,23 @@ func goVNext(pCursor unsafe.Pointer) *C.char {
 }
 
 //export goVEof
-func goVEof(pCursor unsafe.Pointer) C.int {
-	vtc := lookupHandle(uintptr(pCursor)).(*sqliteVTabCursor)
+func goVEof(pCursor unsafe.Pointer) (eof C.int) {
+	var vtc *sqliteVTabCursor
+	defer func() {
+		if r := recover(); r != nil {
+			// Claim there are more rows, so that SQLite calls another
+			// method of the cursor, which will return the error.
+			err := callbackPanicError(r)
+			if vtc != nil {
+				vtc.err = err
+			}
+			eof = 0
+		}
+	}()
+	vtc = lookupHandle(uintptr(pCursor)).(*sqliteVTabCursor)
+	if vtc.err != nil {
+		return 0
+	}
 	err := vtc.vTabCursor.EOF()
 	if err {
 		return 1
@@ -495,8 +867,12 @@ func goVEof(pCursor unsafe.Pointer) C.int {
 }
 
 //export goVColumn
-func goVColumn(pCursor, cp unsafe.Pointer, col C.int) *C.char {
+func goVColumn(pCursor, cp unsafe.Pointer, col C.int) (zErr *C.char) {
+	defer recoverVTab(&zErr)
 	vtc := lookupHandle(uintptr(pCursor)).(*sqliteVTabCursor)
+	if vtc.err != nil {
+		return mPrintf("%s", vtc.err.Error())
+	}
 	c := (*SQLiteContext)(cp)
 	err := vtc.vTabCursor.Column(c, int(col))
 	if err != nil {
@@ -506,8 +882,12 @@ func goVColumn(pCursor, cp unsafe.Pointer, col C.int) *C.char {
 }
 
 //export goVRowid
-func goVRowid(pCursor unsafe.Pointer, pRowid *C.sqlite3_int64) *C.char {
+func goVRowid(pCursor unsafe.Pointer, pRowid *C.sqlite3_int64) (zErr *C.char) {
+	defer recoverVTab(&zErr)
 	vtc := lookupHandle(uintptr(pCursor)).(*sqliteVTabCursor)
+	if vtc.err != nil {
+		return mPrintf("%s", vtc.err.Error())
+	}
 	rowid, err := vtc.vTabCursor.Rowid()
 	if err != nil {
 		return mPrintf("%s", err.Error())
@@ -517,7 +897,8 @@ func goVRowid(pCursor unsafe.Pointer, pRowid *C.sqlite3_int64) *C.char {
 }
 
 //export goVUpdate
-func goVUpdate(pVTab unsafe.Pointer, argc C.int, argv **C.sqlite3_value, pRowid *C.sqlite3_int64) *C.char {
+func goVUpdate(pVTab unsafe.Pointer, argc C.int, argv **C.sqlite3_value, pRowid *C.sqlite3_int64) (zErr *C.char) {
+	defer recoverVTab(&zErr)
 	vt := lookupHandle(uintptr(pVTab)).(*sqliteVTab)
 
 	var tname string
@@ -570,6 +951,212 @@ func goVUpdate(pVTab unsafe.Pointer, argc C.int, argv **C.sqlite3_value, pRowid
 	return nil
 }
 
+//export goVBegin
+func goVBegin(pVTab unsafe.Pointer) (zErr *C.char) {
+	defer recoverVTab(&zErr)
+	vt := lookupHandle(uintptr(pVTab)).(*sqliteVTab)
+	if t, ok := vt.vTab.(VTabTransactor); ok {
+		if err := t.Begin(); err != nil {
+			return mPrintf("%s", err.Error())
+		}
+	}
+	return nil
+}
+
+//export goVSync
+func goVSync(pVTab unsafe.Pointer) (zErr *C.char) {
+	defer recoverVTab(&zErr)
+	vt := lookupHandle(uintptr(pVTab)).(*sqliteVTab)
+	if t, ok := vt.vTab.(VTabTransactor); ok {
+		if err := t.Sync(); err != nil {
+			return mPrintf("%s", err.Error())
+		}
+	}
+	return nil
+}
+
+//export goVCommit
+func goVCommit(pVTab unsafe.Pointer) (zErr *C.char) {
+	defer recoverVTab(&zErr)
+	vt := lookupHandle(uintptr(pVTab)).(*sqliteVTab)
+	if t, ok := vt.vTab.(VTabTransactor); ok {
+		if err := t.Commit(); err != nil {
+			return mPrintf("%s", err.Error())
+		}
+	}
+	return nil
+}
+
+//export goVRollback
+func goVRollback(pVTab unsafe.Pointer) (zErr *C.char) {
+	defer recoverVTab(&zErr)
+	vt := lookupHandle(uintptr(pVTab)).(*sqliteVTab)
+	if t, ok := vt.vTab.(VTabTransactor); ok {
+		if err := t.Rollback(); err != nil {
+			return mPrintf("%s", err.Error())
+		}
+	}
+	return nil
+}
+
+//export goVSavepoint
+func goVSavepoint(pVTab unsafe.Pointer, i C.int) (zErr *C.char) {
+	defer recoverVTab(&zErr)
+	vt := lookupHandle(uintptr(pVTab)).(*sqliteVTab)
+	if s, ok := vt.vTab.(VTabSavepointer); ok {
+		if err := s.Savepoint(int(i)); err != nil {
+			return mPrintf("%s", err.Error())
+		}
+	}
+	return nil
+}
+
+//export goVReleaseSavepoint
+func goVReleaseSavepoint(pVTab unsafe.Pointer, i C.int) (zErr *C.char) {
+	defer recoverVTab(&zErr)
+	vt := lookupHandle(uintptr(pVTab)).(*sqliteVTab)
+	if s, ok := vt.vTab.(VTabSavepointer); ok {
+		if err := s.Release(int(i)); err != nil {
+			return mPrintf("%s", err.Error())
+		}
+	}
+	return nil
+}
+
+//export goVRollbackTo
+func goVRollbackTo(pVTab unsafe.Pointer, i C.int) (zErr *C.char) {
+	defer recoverVTab(&zErr)
+	vt := lookupHandle(uintptr(pVTab)).(*sqliteVTab)
+	if s, ok := vt.vTab.(VTabSavepointer); ok {
+		if err := s.RollbackTo(int(i)); err != nil {
+			return mPrintf("%s", err.Error())
+		}
+	}
+	return nil
+}
+
+//export goVRename
+func goVRename(pVTab unsafe.Pointer, zNew *C.char) (zErr *C.char) {
+	defer recoverVTab(&zErr)
+	vt := lookupHandle(uintptr(pVTab)).(*sqliteVTab)
+	if r, ok := vt.vTab.(VTabRenamer); ok {
+		if err := r.Rename(C.GoString(zNew)); err != nil {
+			return mPrintf("%s", err.Error())
+		}
+	}
+	return nil
+}
+
+//export goVFindFunction
+func goVFindFunction(pVTab unsafe.Pointer, nArg C.int, zName *C.char, pxFunc *unsafe.Pointer, pArg *C.uintptr_t) (found C.int) {
+	// xFindFunction can't report errors: a panic leaves the function to
+	// its global implementation.
+	defer recoverDestroy()
+	vt := lookupHandle(uintptr(pVTab)).(*sqliteVTab)
+	ff, ok := vt.vTab.(VTabFunctionFinder)
+	if !ok {
+		return 0
+	}
+	name := C.GoString(zName)
+	key := fmt.Sprintf("%s/%d", name, nArg)
+	h, ok := vt.funcs[key]
+	if !ok {
+		h = vt.newFunction(ff.FindFunction(name, int(nArg)), int(nArg))
+		if vt.funcs == nil {
+			vt.funcs = make(map[string]uintptr)
+		}
+		vt.funcs[key] = h
+	}
+	if h == 0 {
+		return 0
+	}
+	_, raw := lookupHandle(h).(RawFunc)
+	*pxFunc = functionTrampoline(raw)
+	*pArg = C.uintptr_t(h)
+	return 1
+}
+
+// newFunction returns the handle of an implementation returned by
+// FindFunction, or 0 if it is nil or can't be called with nArg arguments.
+func (vt *sqliteVTab) newFunction(impl interface{}, nArg int) uintptr {
+	switch f := impl.(type) {
+	case nil:
+		return 0
+	case RawFunc:
+		return newHandle(vt.module.c, f)
+	case func(*SQLiteContext, []SQLiteValue):
+		return newHandle(vt.module.c, RawFunc(f))
+	}
+	fi, numArgs, err := newFunctionInfo(impl)
+	if err != nil || (numArgs != -1 && numArgs != nArg) {
+		return 0
+	}
+	return newHandle(vt.module.c, fi)
+}
+
+// shadowSlots holds the ShadowNamer of each slot of xShadowName
+// trampolines, and the number of modules registered with it.
+var shadowSlots struct {
+	sync.Mutex
+	slots [C.GO_SHADOWNAME_SLOTS]struct {
+		namer ShadowNamer
+		refs  int
+	}
+}
+
+// acquireShadowSlot returns the slot of sn, which is shared by all the
+// registrations of the same module, or -1 if SQLite doesn't support
+// ShadowNamer.
+func acquireShadowSlot(sn ShadowNamer) (int, error) {
+	if len(shadowSlots.slots) == 0 {
+		return -1, nil
+	}
+	shadowSlots.Lock()
+	defer shadowSlots.Unlock()
+	comparable := reflect.TypeOf(sn).Comparable()
+	free := -1
+	for i := range shadowSlots.slots {
+		s := &shadowSlots.slots[i]
+		if s.refs == 0 {
+			if free < 0 {
+				free = i
+			}
+			continue
+		}
+		if comparable && s.namer == sn {
+			s.refs++
+			return i, nil
+		}
+	}
+	if free < 0 {
+		return -1, fmt.Errorf("too many ShadowNamer modules, at most %d can be registered", len(shadowSlots.slots))
+	}
+	shadowSlots.slots[free].namer = sn
+	shadowSlots.slots[free].refs = 1
+	return free, nil
+}
+
+func releaseShadowSlot(slot int) {
+	shadowSlots.Lock()
+	defer shadowSlots.Unlock()
+	s := &shadowSlots.slots[slot]
+	if s.refs--; s.refs == 0 {
+		s.namer = nil
+	}
+}
+
+//export goMShadowName
+func goMShadowName(slot C.int, zName *C.char) (found C.int) {
+	defer recoverDestroy()
+	shadowSlots.Lock()
+	sn := shadowSlots.slots[slot].namer
+	shadowSlots.Unlock()
+	if sn != nil && sn.ShadowName(C.GoString(zName)) {
+		return 1
+	}
+	return 0
+}
+
 // Module is a "virtual table module", it defines the implementation of a
 // virtual tables. See: http://sqlite.org/c3ref/module.html
 type Module interface {
@@ -581,6 +1168,19 @@ type Module interface {
 	DestroyModule()
 }
 
+// EponymousModule is a Module whose virtual table exists in every database
+// under the name of the module, like table-valued functions do.
+//
+// Create is never called for the tables of an eponymous module: Connect
+// is called instead, both for the eponymous table and for the tables
+// created with CREATE VIRTUAL TABLE. If EponymousOnly returns true, CREATE
+// VIRTUAL TABLE can't use the module at all.
+// See: https://sqlite.org/vtab.html#eponymous_virtual_tables
+type EponymousModule interface {
+	Module
+	EponymousOnly() bool
+}
+
 // VTab describes a particular instance of the virtual table.
 // See: http://sqlite.org/c3ref/vtab.html
 type VTab interface {
@@ -594,6 +1194,14 @@ type VTab interface {
 	Open() (VTabCursor, error)
 }
 
+// VTabPlanner is a VTab that gets all the inputs of xBestIndex, including
+// those that don't fit in the arguments of BestIndex. BestIndexInfo is
+// called in place of BestIndex.
+// See: https://sqlite.org/vtab.html#xbestindex
+type VTabPlanner interface {
+	BestIndexInfo(info *IndexInfo) (*IndexResult, error)
+}
+
 // VTabUpdater is a type that allows a VTab to be inserted, updated, or
 // deleted.
 // See: https://sqlite.org/vtab.html#xupdate
@@ -603,12 +1211,87 @@ type VTabUpdater interface {
 	Update(interface{}, []interface{}) error
 }
 
+// VTabRenamer is a VTab that is told when ALTER TABLE renames it, before
+// the rename happens. Returning an error prevents the rename.
+// See: https://sqlite.org/vtab.html#xrename
+type VTabRenamer interface {
+	// http://sqlite.org/vtab.html#xrename
+	Rename(newName string) error
+}
+
+// VTabFunctionFinder is a VTab that overloads SQL functions whose first
+// argument is one of its columns, for instance to implement the MATCH
+// operator, which calls match(x, y) for "x MATCH y".
+//
+// SQLite only asks for the functions that exist on the connection, so each
+// overloaded function must also be registered, for instance with
+// RegisterFunc, or be declared with OverloadFunction.
+// See: https://sqlite.org/vtab.html#xfindfunction
+type VTabFunctionFinder interface {
+	// FindFunction returns the implementation of the function with the
+	// given name and number of arguments, or nil to keep the one
+	// registered on the connection. It can be a RawFunc, or any function
+	// accepted by RegisterFunc. It is called when statements are
+	// prepared, once per name and number of arguments for each table.
+	FindFunction(name string, nArg int) interface{}
+}
+
+// ShadowNamer is a Module that stores the content of its virtual tables in
+// shadow tables, named after the virtual table followed by an underscore
+// and a suffix. ShadowName reports whether the suffix is one of those it
+// uses. SQLite then protects these tables from changes by untrusted SQL
+// when the connection is in defensive mode. It requires SQLite 3.26.0 or
+// later.
+//
+// At most 64 distinct ShadowNamer modules can be registered at a time in the
+// process. Registering the same module on several connections counts once
+// when its type is comparable, for instance when it is a pointer.
+// See: https://sqlite.org/vtab.html#the_xshadowname_method
+type ShadowNamer interface {
+	ShadowName(suffix string) bool
+}
+
+// VTabTransactor is a VTab that takes part in the transactions of the
+// connection, for instance to apply the changes made by a VTabUpdater to an
+// external store only when the transaction commits. Begin is called before
+// the first change to the table in a transaction, and Sync is the first
+// phase of a two-phase commit with the other tables of the transaction.
+// See: https://sqlite.org/vtab.html#xBegin
+type VTabTransactor interface {
+	// http://sqlite.org/vtab.html#xBegin
+	Begin() error
+	// http://sqlite.org/vtab.html#xsync
+	Sync() error
+	// http://sqlite.org/vtab.html#xcommit
+	Commit() error
+	// http://sqlite.org/vtab.html#xrollback
+	Rollback() error
+}
+
+// VTabSavepointer is a VTab that supports savepoints within a transaction.
+// The savepoints are identified by their nesting level n: Savepoint starts
+// level n, Release merges the levels n and above into the enclosing one, and
+// RollbackTo undoes the changes made since level n started.
+// See: https://sqlite.org/vtab.html#xsavepoint
+type VTabSavepointer interface {
+	// http://sqlite.org/vtab.html#xsavepoint
+	Savepoint(n int) error
+	// http://sqlite.org/vtab.html#xsavepoint
+	Release(n int) error
+	// http://sqlite.org/vtab.html#xsavepoint
+	RollbackTo(n int) error
+}
+
 // VTabCursor describes cursors that point into the virtual table and are used
 // to loop through the virtual table. See: http://sqlite.org/c3ref/vtab_cursor.html
 type VTabCursor interface {
 	// http://sqlite.org/vtab.html#xclose
 	Close() error
 	// http://sqlite.org/vtab.html#xfilter
+	//
+	// vals holds the values of the constraints selected by the IndexResult
+	// of BestIndex, in the order it gave them. The values of the IN
+	// constraints marked in IndexResult.In are []interface{}.
 	Filter(idxNum int, idxStr string, vals []interface{}) error
 	// http://sqlite.org/vtab.html#xnext
 	Next() error
@@ -620,6 +1303,21 @@ type VTabCursor interface {
 	Rowid() (int64, error)
 }
 
+// OverloadFunction declares the SQL function name with nArg arguments, if
+// the connection has none, so that a VTabFunctionFinder can overload it.
+// Calling the function on anything else than the columns of such a table
+// is an error.
+// See: https://sqlite.org/c3ref/overload_function.html
+func (c *SQLiteConn) OverloadFunction(name string, nArg int) error {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	rv := C.sqlite3_overload_function(c.db, cname, C.int(nArg))
+	if rv != C.SQLITE_OK {
+		return c.lastError()
+	}
+	return nil
+}
+
 // DeclareVTab declares the Schema of a virtual table.
 // See: http://sqlite.org/c3ref/declare_vtab.html
 func (c *SQLiteConn) DeclareVTab(sql string) error {
@@ -632,13 +1330,29 @@ func (c *SQLiteConn) DeclareVTab(sql string) error {
 	return nil
 }
 
-// CreateModule registers a virtual table implementation.
+// CreateModule registers a virtual table implementation. If module is an
+// EponymousModule, its table can also be used under the name of the module
+// without creating it first.
 // See: http://sqlite.org/c3ref/create_module.html
 func (c *SQLiteConn) CreateModule(moduleName string, module Module) error {
 	mname := C.CString(moduleName)
 	defer C.free(unsafe.Pointer(mname))
-	udm := sqliteModule{c, moduleName, module}
-	rv := C._sqlite3_create_module(c.db, mname, C.uintptr_t(newHandle(c, &udm)))
+	udm := sqliteModule{c, moduleName, module, -1}
+	if sn, ok := module.(ShadowNamer); ok {
+		slot, err := acquireShadowSlot(sn)
+		if err != nil {
+			return err
+		}
+		udm.slot = slot
+	}
+	eponymous := 0
+	if m, ok := module.(EponymousModule); ok {
+		eponymous = 1
+		if m.EponymousOnly() {
+			eponymous = 2
+		}
+	}
+	rv := C._sqlite3_create_module(c.db, mname, C.uintptr_t(newHandle(c, &udm)), C.int(eponymous), C.int(udm.slot))
 	if rv != C.SQLITE_OK {
 		return c.lastError()
 	}
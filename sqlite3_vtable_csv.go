@@ -0,0 +1,315 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CSVModule is a Module whose virtual tables read CSV data, like the csv
+// extension of SQLite. The rows are read from the data at each scan, so
+// changes to it are seen by the next queries. Tables are created with
+// arguments of the form key=value:
+//
+//	CREATE VIRTUAL TABLE t USING csv(filename='data.csv', header=yes)
+//
+// filename is the path of the CSV file, and must be omitted when Reader is
+// set. header tells whether the first row holds the names of the columns,
+// which are named c0, c1... otherwise. schema is the CREATE TABLE statement
+// that declares the columns instead, such as 'CREATE TABLE x(a INT, b)', and
+// columns is their number when no row tells it.
+//
+// The values are TEXT, including for the columns declared with another type,
+// and NULL for the fields missing from short rows. The rowid is the number
+// of the row, not counting the header. The tables are read-only.
+type CSVModule struct {
+	// Reader is the data of all the tables of the module when it is set,
+	// Size bytes long.
+	Reader io.ReaderAt
+	Size   int64
+	// Comma is the field delimiter, ',' if it is 0.
+	Comma rune
+}
+
+// Create implements Module.
+func (m *CSVModule) Create(c *SQLiteConn, args []string) (VTab, error) {
+	return m.Connect(c, args)
+}
+
+// Connect implements Module.
+func (m *CSVModule) Connect(c *SQLiteConn, args []string) (VTab, error) {
+	v := &csvVTab{module: m, size: m.Size}
+	var schema string
+	columns := -1
+	for _, arg := range args[3:] {
+		kv := strings.SplitN(arg, "=", 2)
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("csv: argument %q is not key=value", arg)
+		}
+		val := csvUnquote(strings.TrimSpace(kv[1]))
+		switch key {
+		case "filename":
+			if m.Reader != nil {
+				return nil, errors.New("csv: filename can't be used with a Reader")
+			}
+			v.filename = val
+		case "header":
+			switch strings.ToLower(val) {
+			case "yes", "true", "on", "1":
+				v.header = true
+			case "no", "false", "off", "0":
+				v.header = false
+			default:
+				return nil, fmt.Errorf("csv: header must be a boolean, got %q", val)
+			}
+		case "schema":
+			schema = val
+		case "columns":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("csv: columns must be a positive integer, got %q", val)
+			}
+			columns = n
+		default:
+			return nil, fmt.Errorf("csv: unknown argument %q", key)
+		}
+	}
+	if m.Reader == nil {
+		if v.filename == "" {
+			return nil, errors.New("csv: missing filename")
+		}
+		fi, err := os.Stat(v.filename)
+		if err != nil {
+			return nil, err
+		}
+		v.size = fi.Size()
+	}
+
+	// Read the first row for the names or the number of the columns.
+	var names []string
+	if columns < 0 || (v.header && schema == "") {
+		r, closer, err := v.open()
+		if err != nil {
+			return nil, err
+		}
+		first, err := r.Read()
+		closer.Close()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if columns < 0 {
+			columns = len(first)
+		}
+		if v.header {
+			names = first
+		}
+	}
+	if columns <= 0 {
+		return nil, errors.New("csv: no columns")
+	}
+	if schema == "" {
+		decls := make([]string, columns)
+		for i := range decls {
+			name := fmt.Sprintf("c%d", i)
+			if i < len(names) && names[i] != "" {
+				name = names[i]
+			}
+			decls[i] = `"` + strings.Replace(name, `"`, `""`, -1) + `" TEXT`
+		}
+		schema = fmt.Sprintf("CREATE TABLE x(%s)", strings.Join(decls, ", "))
+	}
+	if err := c.DeclareVTab(schema); err != nil {
+		return nil, err
+	}
+	rows, err := v.estimateRows()
+	if err != nil {
+		return nil, err
+	}
+	v.rows = rows
+	return v, nil
+}
+
+// DestroyModule implements Module.
+func (m *CSVModule) DestroyModule() {}
+
+// csvUnquote removes the SQL quotes around a module argument.
+func csvUnquote(s string) string {
+	if len(s) < 2 {
+		return s
+	}
+	switch q := s[0]; q {
+	case '\'', '"':
+		if s[len(s)-1] == q {
+			return strings.Replace(s[1:len(s)-1], string([]byte{q, q}), string(q), -1)
+		}
+	}
+	return s
+}
+
+type csvVTab struct {
+	module   *CSVModule
+	filename string
+	size     int64
+	header   bool
+	rows     float64 // estimated number of rows
+}
+
+// open returns a reader of the data from its start.
+func (v *csvVTab) open() (*csv.Reader, io.Closer, error) {
+	var src io.Reader
+	var closer io.Closer = ioutil.NopCloser(nil)
+	if v.module.Reader != nil {
+		src = io.NewSectionReader(v.module.Reader, 0, v.size)
+	} else {
+		f, err := os.Open(v.filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		src, closer = f, f
+	}
+	r := csv.NewReader(src)
+	if v.module.Comma != 0 {
+		r.Comma = v.module.Comma
+	}
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	return r, closer, nil
+}
+
+// estimateRows estimates the number of rows from the number of lines at the
+// start of the data.
+func (v *csvVTab) estimateRows() (float64, error) {
+	if v.size == 0 {
+		return 1, nil
+	}
+	var src io.Reader
+	if v.module.Reader != nil {
+		src = io.NewSectionReader(v.module.Reader, 0, v.size)
+	} else {
+		f, err := os.Open(v.filename)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		src = f
+	}
+	buf := make([]byte, 64*1024)
+	n, err := io.ReadFull(src, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+	lines := bytes.Count(buf[:n], []byte{'\n'})
+	if n > 0 && buf[n-1] != '\n' {
+		lines++
+	}
+	rows := float64(lines) * float64(v.size) / float64(n)
+	if v.header {
+		rows--
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	return rows, nil
+}
+
+// BestIndex always scans the whole data.
+func (v *csvVTab) BestIndex(csts []InfoConstraint, ob []InfoOrderBy) (*IndexResult, error) {
+	return &IndexResult{
+		Used:          make([]bool, len(csts)),
+		EstimatedCost: v.rows,
+		EstimatedRows: v.rows,
+	}, nil
+}
+
+func (v *csvVTab) Disconnect() error {
+	return nil
+}
+
+func (v *csvVTab) Destroy() error {
+	return nil
+}
+
+func (v *csvVTab) Open() (VTabCursor, error) {
+	return &csvVTabCursor{vTab: v}, nil
+}
+
+type csvVTabCursor struct {
+	vTab   *csvVTab
+	r      *csv.Reader
+	closer io.Closer
+	row    []string
+	rowid  int64
+	eof    bool
+}
+
+func (vc *csvVTabCursor) Filter(idxNum int, idxStr string, vals []interface{}) error {
+	if err := vc.Close(); err != nil {
+		return err
+	}
+	r, closer, err := vc.vTab.open()
+	if err != nil {
+		return err
+	}
+	vc.r, vc.closer = r, closer
+	vc.rowid = 0
+	vc.eof = false
+	if vc.vTab.header {
+		if _, err := r.Read(); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return vc.Next()
+}
+
+func (vc *csvVTabCursor) Next() error {
+	row, err := vc.r.Read()
+	if err == io.EOF {
+		vc.row, vc.eof = nil, true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	vc.row = row
+	vc.rowid++
+	return nil
+}
+
+func (vc *csvVTabCursor) EOF() bool {
+	return vc.eof
+}
+
+func (vc *csvVTabCursor) Column(c *SQLiteContext, col int) error {
+	switch {
+	case col >= len(vc.row):
+		c.ResultNull()
+	default:
+		c.ResultText(vc.row[col])
+	}
+	return nil
+}
+
+func (vc *csvVTabCursor) Rowid() (int64, error) {
+	return vc.rowid, nil
+}
+
+func (vc *csvVTabCursor) Close() error {
+	if vc.closer == nil {
+		return nil
+	}
+	err := vc.closer.Close()
+	vc.r, vc.closer = nil, nil
+	return err
+}
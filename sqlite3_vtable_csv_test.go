@@ -0,0 +1,157 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const csvTestData = `name,age,city
+alice,30,Paris
+bob,,"New York, NY"
+carol,25
+`
+
+func queryCSV(t *testing.T, db *sql.DB, query string) [][]interface{} {
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatalf("could not query %q: %v", query, err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		t.Fatalf("could not get columns: %v", err)
+	}
+	var got [][]interface{}
+	for rows.Next() {
+		row := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			t.Fatalf("could not scan: %v", err)
+		}
+		for i, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[i] = string(b)
+			}
+		}
+		got = append(got, row)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("could not read rows: %v", err)
+	}
+	return got
+}
+
+func TestCSVModule(t *testing.T) {
+	tempFilename := TempFilename(t)
+	defer os.Remove(tempFilename)
+	if err := ioutil.WriteFile(tempFilename, []byte(csvTestData), 0644); err != nil {
+		t.Fatalf("could not write CSV file: %v", err)
+	}
+	reader := strings.NewReader("1;2\n3;4\n")
+	sql.Register("sqlite3_TestCSVModule", &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			if err := conn.CreateModule("csv", &CSVModule{}); err != nil {
+				return err
+			}
+			return conn.CreateModule("csvreader", &CSVModule{Reader: reader, Size: reader.Size(), Comma: ';'})
+		},
+	})
+	db, err := sql.Open("sqlite3_TestCSVModule", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	stmts := []string{
+		"CREATE VIRTUAL TABLE people USING csv(filename='" + tempFilename + "', header=yes)",
+		"CREATE VIRTUAL TABLE raw USING csv(filename=\"" + tempFilename + "\")",
+		"CREATE VIRTUAL TABLE typed USING csv(filename='" + tempFilename + "', header=on, schema='CREATE TABLE x(n, a INT)')",
+		"CREATE VIRTUAL TABLE numbers USING csvreader(columns=2)",
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("could not create vtable: %v", err)
+		}
+	}
+
+	got := queryCSV(t, db, "SELECT rowid, name, age, city FROM people")
+	want := [][]interface{}{
+		{int64(1), "alice", "30", "Paris"},
+		{int64(2), "bob", "", "New York, NY"},
+		{int64(3), "carol", "25", nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	got = queryCSV(t, db, "SELECT c0, c2 FROM raw WHERE c1 = 'age'")
+	if want := [][]interface{}{{"name", "city"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	got = queryCSV(t, db, "SELECT n, a FROM typed LIMIT 1")
+	if want := [][]interface{}{{"alice", "30"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	got = queryCSV(t, db, "SELECT sum(c0), sum(c1) FROM numbers")
+	if want := [][]interface{}{{int64(4), int64(6)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// The data is read again at each scan.
+	if err := ioutil.WriteFile(tempFilename, []byte(csvTestData+"dave,40,Rome\n"), 0644); err != nil {
+		t.Fatalf("could not write CSV file: %v", err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM people").Scan(&n); err != nil {
+		t.Fatalf("could not query vtable: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("Expected 4 rows, got %d", n)
+	}
+
+	for _, stmt := range []string{
+		"CREATE VIRTUAL TABLE bad USING csv()",
+		"CREATE VIRTUAL TABLE bad USING csv(filename='/nonexistent.csv')",
+		"CREATE VIRTUAL TABLE bad USING csv(filename='" + tempFilename + "', header=maybe)",
+		"CREATE VIRTUAL TABLE bad USING csv(filename='" + tempFilename + "', unknown=1)",
+		"CREATE VIRTUAL TABLE bad USING csvreader(filename='" + tempFilename + "')",
+		"INSERT INTO people VALUES ('eve', 1, 'Oslo')",
+	} {
+		if _, err := db.Exec(stmt); err == nil {
+			t.Errorf("Expected %q to fail", stmt)
+		}
+	}
+}
+
+func TestCSVModuleEstimatedRows(t *testing.T) {
+	data := "a,b\n" + strings.Repeat("1,2\n", 100000)
+	r := strings.NewReader(data)
+	v := &csvVTab{module: &CSVModule{Reader: r}, size: r.Size(), header: true}
+	rows, err := v.estimateRows()
+	if err != nil {
+		t.Fatalf("could not estimate rows: %v", err)
+	}
+	v.rows = rows
+	if rows < 90000 || rows > 110000 {
+		t.Errorf("Expected about 100000 rows, got %v", rows)
+	}
+	res, err := v.BestIndex(nil, nil)
+	if err != nil {
+		t.Fatalf("BestIndex failed: %v", err)
+	}
+	if res.EstimatedRows != rows {
+		t.Errorf("Expected EstimatedRows to be %v, got %v", rows, res.EstimatedRows)
+	}
+}
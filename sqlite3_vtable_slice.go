@@ -0,0 +1,573 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#ifndef USE_LIBSQLITE3
+#include <sqlite3-binding.h>
+#else
+#include <sqlite3.h>
+#endif
+*/
+import "C"
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// NewSliceModule returns a Module whose virtual tables expose the elements
+// of a slice of structs, one row per element and one column per exported
+// field. data is one of:
+//
+//	[]T          the rows of a read-only table
+//	func() []T   called at each scan for the rows of a read-only table
+//	*[]T         the rows of a table that INSERT, UPDATE and DELETE modify
+//
+// where T is a struct or a pointer to a struct. The fields of embedded
+// structs are columns of their own. A field tag changes the name of the
+// column, and the index option marks the columns that are often compared
+// for equality, which the table then filters first:
+//
+//	type User struct {
+//		ID    int64  `sqlite:"id,index"`
+//		Name  string `sqlite:"name"`
+//		Token string `sqlite:"-"` // not a column
+//	}
+//
+// The values of the fields are converted like the results of functions
+// registered with RegisterFunc, and back like their arguments. The table can
+// also be used under the name of the module without creating it first:
+//
+//	conn.CreateModule("users", module)
+//	db.Query("SELECT name FROM users WHERE id = ?", 1)
+//
+// The rowids of writable tables can't be changed. When the slice is
+// modified outside of SQL, the rowids are kept for the elements that were
+// appended, and renumbered otherwise. The module locks the slice while SQL
+// reads or writes it, including from several connections, but nothing else
+// does, so it must not be modified while it is in use by a connection.
+func NewSliceModule(data interface{}) (Module, error) {
+	m := &sliceModule{}
+	v := reflect.ValueOf(data)
+	var typ reflect.Type
+	switch {
+	case v.Kind() == reflect.Slice:
+		typ = v.Type()
+		m.rows = func() reflect.Value { return v }
+	case v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.Slice && !v.IsNil():
+		typ = v.Type().Elem()
+		m.target = v.Elem()
+		m.rows = func() reflect.Value { return v.Elem() }
+	case v.Kind() == reflect.Func && v.Type().NumIn() == 0 && v.Type().NumOut() == 1 &&
+		v.Type().Out(0).Kind() == reflect.Slice && !v.IsNil():
+		typ = v.Type().Out(0)
+		m.rows = func() reflect.Value { return v.Call(nil)[0] }
+	default:
+		return nil, fmt.Errorf("expected a slice, a pointer to a slice or a function returning a slice, got %T", data)
+	}
+	m.elem = typ.Elem()
+	if m.elem.Kind() == reflect.Ptr {
+		m.ptr = true
+		m.elem = m.elem.Elem()
+	}
+	if m.elem.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected a slice of structs, got %s", typ)
+	}
+	if err := m.addColumns(m.elem, nil); err != nil {
+		return nil, err
+	}
+	if len(m.columns) == 0 {
+		return nil, fmt.Errorf("%s has no exported fields", m.elem)
+	}
+	return m, nil
+}
+
+type sliceModule struct {
+	elem    reflect.Type // the struct type of the rows
+	ptr     bool         // the elements of the slice are *elem
+	rows    func() reflect.Value
+	target  reflect.Value // the slice that writes modify, if any
+	columns []sliceColumn
+
+	// mu guards target, ids and next, since the module is shared by all
+	// the connections it is registered on.
+	mu sync.Mutex
+	// ids holds the rowids of the elements of target, and next the rowid
+	// of the next inserted element.
+	ids  []int64
+	next int64
+}
+
+type sliceColumn struct {
+	name    string
+	field   []int // index of the field in elem
+	typ     reflect.Type
+	indexed bool
+	ret     callbackRetConverter
+}
+
+func (m *sliceModule) addColumns(typ reflect.Type, index []int) error {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag := strings.Split(f.Tag.Get("sqlite"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		field := append(append([]int{}, index...), i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && tag[0] == "" {
+			if err := m.addColumns(f.Type, field); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		ret, err := callbackRet(f.Type)
+		if err != nil {
+			return fmt.Errorf("field %s: %v", f.Name, err)
+		}
+		col := sliceColumn{name: f.Name, field: field, typ: f.Type, ret: ret}
+		if tag[0] != "" {
+			col.name = tag[0]
+		}
+		for _, opt := range tag[1:] {
+			switch opt {
+			case "index":
+				col.indexed = true
+			default:
+				return fmt.Errorf("field %s: unknown option %q", f.Name, opt)
+			}
+		}
+		m.columns = append(m.columns, col)
+	}
+	return nil
+}
+
+// declType returns the declared type of a column, which tells the driver how
+// to scan it.
+func (col *sliceColumn) declType() string {
+	typ := col.typ
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Bool:
+		return "BOOLEAN"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "INTEGER"
+	case reflect.Float32, reflect.Float64:
+		return "REAL"
+	case reflect.String:
+		return "TEXT"
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return "BLOB"
+		}
+	case reflect.Struct:
+		if typ == timeType {
+			return "TIMESTAMP"
+		}
+	}
+	return ""
+}
+
+func (m *sliceModule) EponymousOnly() bool {
+	return false
+}
+
+func (m *sliceModule) Create(c *SQLiteConn, args []string) (VTab, error) {
+	return m.Connect(c, args)
+}
+
+func (m *sliceModule) Connect(c *SQLiteConn, args []string) (VTab, error) {
+	decls := make([]string, len(m.columns))
+	for i, col := range m.columns {
+		decls[i] = strings.TrimSpace(`"` + strings.Replace(col.name, `"`, `""`, -1) + `" ` + col.declType())
+	}
+	err := c.DeclareVTab(fmt.Sprintf("CREATE TABLE x(%s)", strings.Join(decls, ", ")))
+	if err != nil {
+		return nil, err
+	}
+	if m.target.IsValid() {
+		return &sliceUpdateVTab{sliceVTab{m}}, nil
+	}
+	return &sliceVTab{m}, nil
+}
+
+func (m *sliceModule) DestroyModule() {}
+
+// snapshot returns the current rows and their rowids. The rows of a
+// writable table are copied, so that later writes don't move them during a
+// scan.
+func (m *sliceModule) snapshot() (reflect.Value, []int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rows, ids := m.refresh()
+	if !m.target.IsValid() {
+		return rows, nil
+	}
+	copied := reflect.MakeSlice(rows.Type(), rows.Len(), rows.Len())
+	reflect.Copy(copied, rows)
+	return copied, append([]int64(nil), ids...)
+}
+
+// refresh returns the current rows and their rowids, numbering the rows
+// appended outside of SQL. m.mu must be held.
+func (m *sliceModule) refresh() (reflect.Value, []int64) {
+	rows := m.rows()
+	if !m.target.IsValid() {
+		return rows, nil
+	}
+	if len(m.ids) > rows.Len() {
+		m.ids, m.next = nil, 0
+	}
+	for len(m.ids) < rows.Len() {
+		m.next++
+		m.ids = append(m.ids, m.next)
+	}
+	return rows, m.ids
+}
+
+type sliceVTab struct {
+	module *sliceModule
+}
+
+// BestIndex filters on the first equality constraint of an indexed column,
+// and lets SQLite check it again. IdxNum is the column plus one, or 0 for a
+// full scan.
+func (v *sliceVTab) BestIndex(csts []InfoConstraint, ob []InfoOrderBy) (*IndexResult, error) {
+	res := &IndexResult{
+		ArgvIndex:     make([]int, len(csts)),
+		EstimatedCost: 1e6,
+		EstimatedRows: 1e6,
+	}
+	for i, cst := range csts {
+		if !cst.Usable || cst.Op != OpEQ || cst.Column < 0 || !v.module.columns[cst.Column].indexed {
+			continue
+		}
+		if cst.Collation != "" && !strings.EqualFold(cst.Collation, "BINARY") {
+			continue
+		}
+		res.ArgvIndex[i] = 1
+		res.IdxNum = cst.Column + 1
+		res.EstimatedCost = 10
+		res.EstimatedRows = 10
+		break
+	}
+	return res, nil
+}
+
+func (v *sliceVTab) Disconnect() error {
+	return nil
+}
+
+func (v *sliceVTab) Destroy() error {
+	return nil
+}
+
+func (v *sliceVTab) Open() (VTabCursor, error) {
+	return &sliceVTabCursor{vTab: v}, nil
+}
+
+// sliceUpdateVTab is a sliceVTab over a *[]T.
+type sliceUpdateVTab struct {
+	sliceVTab
+}
+
+// find returns the index of the row with the given rowid. m.mu must be held.
+func (v *sliceUpdateVTab) find(id interface{}) (int, error) {
+	_, ids := v.module.refresh()
+	for i, x := range ids {
+		if x == id {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no row with rowid %v", id)
+}
+
+func (v *sliceUpdateVTab) Delete(id interface{}) error {
+	m := v.module
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, err := v.find(id)
+	if err != nil {
+		return err
+	}
+	rows := m.target
+	n := rows.Len()
+	reflect.Copy(rows.Slice(i, n), rows.Slice(i+1, n))
+	rows.Index(n - 1).Set(reflect.Zero(rows.Type().Elem()))
+	rows.Set(rows.Slice(0, n-1))
+	m.ids = append(m.ids[:i], m.ids[i+1:]...)
+	return nil
+}
+
+func (v *sliceUpdateVTab) Insert(id interface{}, vals []interface{}) (int64, error) {
+	m := v.module
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refresh()
+	row := reflect.New(m.elem)
+	if err := v.set(row.Elem(), vals); err != nil {
+		return 0, err
+	}
+	var rowid int64
+	switch id := id.(type) {
+	case nil:
+		rowid = m.next + 1
+	case int64:
+		if _, err := v.find(id); err == nil {
+			return 0, fmt.Errorf("rowid %d already exists", id)
+		}
+		rowid = id
+	default:
+		return 0, errors.New("rowid must be an integer")
+	}
+	if !m.ptr {
+		row = row.Elem()
+	}
+	m.target.Set(reflect.Append(m.target, row))
+	m.ids = append(m.ids, rowid)
+	if rowid > m.next {
+		m.next = rowid
+	}
+	return rowid, nil
+}
+
+func (v *sliceUpdateVTab) Update(id interface{}, vals []interface{}) error {
+	v.module.mu.Lock()
+	defer v.module.mu.Unlock()
+	i, err := v.find(id)
+	if err != nil {
+		return err
+	}
+	elem := v.module.target.Index(i)
+	if v.module.ptr {
+		if elem.IsNil() {
+			elem.Set(reflect.New(v.module.elem))
+		}
+		elem = elem.Elem()
+	}
+	// Only modify the row if all the values can be set.
+	row := reflect.New(v.module.elem).Elem()
+	row.Set(elem)
+	if err := v.set(row, vals); err != nil {
+		return err
+	}
+	elem.Set(row)
+	return nil
+}
+
+// set sets the fields of row to the values of the columns.
+func (v *sliceUpdateVTab) set(row reflect.Value, vals []interface{}) error {
+	for i, col := range v.module.columns {
+		if err := sliceSetField(row.FieldByIndex(col.field), vals[i]); err != nil {
+			return fmt.Errorf("column %s: %v", col.name, err)
+		}
+	}
+	return nil
+}
+
+// sliceSetField sets f to the value of a column, as given to VTabUpdater.
+func sliceSetField(f reflect.Value, val interface{}) error {
+	if f.CanAddr() && f.Addr().Type().Implements(scannerType) {
+		return f.Addr().Interface().(sql.Scanner).Scan(val)
+	}
+	if val == nil {
+		f.Set(reflect.Zero(f.Type()))
+		return nil
+	}
+	if f.Kind() == reflect.Ptr {
+		p := reflect.New(f.Type().Elem())
+		if err := sliceSetField(p.Elem(), val); err != nil {
+			return err
+		}
+		f.Set(p)
+		return nil
+	}
+	if f.Type() == timeType {
+		t, err := valueTime(val)
+		if err != nil {
+			return err
+		}
+		f.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.Bool:
+		if i, ok := val.(int64); ok {
+			f.SetBool(i != 0)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := val.(int64); ok && !f.OverflowInt(i) {
+			f.SetInt(i)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if i, ok := val.(int64); ok && i >= 0 && !f.OverflowUint(uint64(i)) {
+			f.SetUint(uint64(i))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch x := val.(type) {
+		case int64:
+			f.SetFloat(float64(x))
+			return nil
+		case float64:
+			f.SetFloat(x)
+			return nil
+		}
+	case reflect.String:
+		switch x := val.(type) {
+		case string:
+			f.SetString(x)
+			return nil
+		case []byte:
+			f.SetString(string(x))
+			return nil
+		}
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.Uint8 {
+			break
+		}
+		switch x := val.(type) {
+		case string:
+			f.SetBytes([]byte(x))
+			return nil
+		case []byte:
+			f.SetBytes(append([]byte(nil), x...))
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot store %T %v in %s", val, val, f.Type())
+}
+
+// sliceMatch reports whether f may be equal to val. SQLite checks the
+// constraint again, so it only needs to be exact for the values that it
+// compares like Go does.
+func sliceMatch(f reflect.Value, val interface{}) bool {
+	if f.Kind() == reflect.Ptr {
+		if f.IsNil() {
+			return false
+		}
+		f = f.Elem()
+	}
+	switch f.Kind() {
+	case reflect.Bool:
+		if i, ok := val.(int64); ok {
+			return f.Bool() == (i != 0) && (i == 0 || i == 1)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch x := val.(type) {
+		case int64:
+			return f.Int() == x
+		case float64:
+			return float64(f.Int()) == x
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		switch x := val.(type) {
+		case int64:
+			return x >= 0 && f.Uint() == uint64(x)
+		case float64:
+			return float64(f.Uint()) == x
+		}
+	case reflect.Float32, reflect.Float64:
+		switch x := val.(type) {
+		case int64:
+			return f.Float() == float64(x)
+		case float64:
+			return f.Float() == x || (math.IsNaN(f.Float()) && math.IsNaN(x))
+		}
+	case reflect.String:
+		if s, ok := val.(string); ok {
+			return f.String() == s
+		}
+	case reflect.Slice:
+		if b, ok := val.([]byte); ok && b != nil && f.Type().Elem().Kind() == reflect.Uint8 {
+			return bytes.Equal(f.Bytes(), b)
+		}
+	}
+	return true
+}
+
+type sliceVTabCursor struct {
+	vTab  *sliceVTab
+	rows  reflect.Value
+	ids   []int64
+	col   int // the filtered column plus one, or 0
+	val   interface{}
+	index int
+	row   reflect.Value // the struct of the current row
+}
+
+func (vc *sliceVTabCursor) Filter(idxNum int, idxStr string, vals []interface{}) error {
+	vc.rows, vc.ids = vc.vTab.module.snapshot()
+	vc.col = idxNum
+	if idxNum > 0 {
+		vc.val = vals[0]
+	}
+	vc.index = -1
+	return vc.Next()
+}
+
+// lock locks the module while the cursor reads a row, when writes may
+// modify the struct that the copied rows point to.
+func (vc *sliceVTabCursor) lock() func() {
+	m := vc.vTab.module
+	if !m.ptr || !m.target.IsValid() {
+		return func() {}
+	}
+	m.mu.Lock()
+	return m.mu.Unlock
+}
+
+func (vc *sliceVTabCursor) Next() error {
+	defer vc.lock()()
+	for vc.index++; vc.index < vc.rows.Len(); vc.index++ {
+		vc.row = vc.rows.Index(vc.index)
+		if vc.vTab.module.ptr {
+			if vc.row.IsNil() {
+				continue
+			}
+			vc.row = vc.row.Elem()
+		}
+		if vc.col == 0 || sliceMatch(vc.row.FieldByIndex(vc.vTab.module.columns[vc.col-1].field), vc.val) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (vc *sliceVTabCursor) EOF() bool {
+	return vc.index >= vc.rows.Len()
+}
+
+func (vc *sliceVTabCursor) Column(c *SQLiteContext, col int) error {
+	defer vc.lock()()
+	column := &vc.vTab.module.columns[col]
+	return column.ret((*C.sqlite3_context)(c), vc.row.FieldByIndex(column.field))
+}
+
+func (vc *sliceVTabCursor) Rowid() (int64, error) {
+	if vc.ids != nil {
+		return vc.ids[vc.index], nil
+	}
+	return int64(vc.index) + 1, nil
+}
+
+func (vc *sliceVTabCursor) Close() error {
+	return nil
+}
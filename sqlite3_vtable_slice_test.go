@@ -0,0 +1,255 @@
+// Copyright (C) 2014 Yasuhiro Matsumoto <mattn.jp@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"database/sql"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type sliceUserBase struct {
+	ID int64 `sqlite:"id,index"`
+}
+
+type sliceUser struct {
+	sliceUserBase
+	Name    string    `sqlite:"name,index"`
+	Email   *string   `sqlite:"email"`
+	Created time.Time `sqlite:"created"`
+	Admin   bool      `sqlite:"admin"`
+	Token   string    `sqlite:"-"`
+	secret  string
+}
+
+func openSliceDB(t *testing.T, name string, modules map[string]interface{}) *sql.DB {
+	sql.Register(name, &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			for name, data := range modules {
+				m, err := NewSliceModule(data)
+				if err != nil {
+					return err
+				}
+				if err := conn.CreateModule(name, m); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+	db, err := sql.Open(name, ":memory:")
+	if err != nil {
+		t.Fatalf("could not open db: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+func TestSliceModule(t *testing.T) {
+	email := "bob@example.com"
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	users := []sliceUser{
+		{sliceUserBase{1}, "alice", nil, created, true, "a", ""},
+		{sliceUserBase{2}, "bob", &email, created, false, "b", ""},
+		{sliceUserBase{3}, "carol", nil, created, false, "c", ""},
+	}
+	db := openSliceDB(t, "sqlite3_TestSliceModule", map[string]interface{}{"users": users})
+	defer db.Close()
+
+	var id int64
+	var name string
+	var mail sql.NullString
+	var when time.Time
+	var admin bool
+	err := db.QueryRow("SELECT id, name, email, created, admin FROM users WHERE id = ?", 2).Scan(&id, &name, &mail, &when, &admin)
+	if err != nil {
+		t.Fatalf("could not query slice: %v", err)
+	}
+	if id != 2 || name != "bob" || mail.String != email || !when.Equal(created) || admin {
+		t.Errorf("Expected bob, got %v %v %v %v %v", id, name, mail, when, admin)
+	}
+	if err := db.QueryRow("SELECT name FROM users WHERE admin").Scan(&name); err != nil {
+		t.Fatalf("could not query slice: %v", err)
+	}
+	if name != "alice" {
+		t.Errorf("Expected alice, got %v", name)
+	}
+
+	// The equality constraints are checked again by SQLite.
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM users WHERE name = 'BOB' COLLATE NOCASE OR id = 3.0").Scan(&n); err != nil {
+		t.Fatalf("could not query slice: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 rows, got %d", n)
+	}
+	if err := db.QueryRow("SELECT count(*) FROM users WHERE id = '1'").Scan(&n); err != nil {
+		t.Fatalf("could not query slice: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 row, got %d", n)
+	}
+
+	if _, err := db.Exec("CREATE VIRTUAL TABLE others USING users()"); err != nil {
+		t.Fatalf("could not create vtable: %v", err)
+	}
+	if err := db.QueryRow("SELECT count(*) FROM others").Scan(&n); err != nil {
+		t.Fatalf("could not query vtable: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Expected 3 rows, got %d", n)
+	}
+	if _, err := db.Exec("DELETE FROM users"); err == nil {
+		t.Error("Expected a read-only table")
+	}
+	if _, err := db.Exec("SELECT token FROM users"); err == nil {
+		t.Error("Expected no token column")
+	}
+}
+
+func TestSliceModuleFunc(t *testing.T) {
+	var users []*sliceUser
+	db := openSliceDB(t, "sqlite3_TestSliceModuleFunc", map[string]interface{}{
+		"users": func() []*sliceUser { return users },
+	})
+	defer db.Close()
+
+	var n int
+	for i := 0; i < 3; i++ {
+		if err := db.QueryRow("SELECT count(*) FROM users").Scan(&n); err != nil {
+			t.Fatalf("could not query slice: %v", err)
+		}
+		if n != i {
+			t.Errorf("Expected %d rows, got %d", i, n)
+		}
+		users = append(users, &sliceUser{Name: "user"}, nil)
+	}
+}
+
+func TestSliceModuleUpdate(t *testing.T) {
+	users := []*sliceUser{{Name: "alice"}, {Name: "bob"}, {Name: "carol"}, {Name: "dave"}}
+	db := openSliceDB(t, "sqlite3_TestSliceModuleUpdate", map[string]interface{}{"users": &users})
+	defer db.Close()
+
+	if _, err := db.Exec("DELETE FROM users WHERE name IN ('alice', 'bob')"); err != nil {
+		t.Fatalf("could not delete: %v", err)
+	}
+	if _, err := db.Exec("UPDATE users SET id = rowid, email = name || '@example.com' WHERE name = 'dave'"); err != nil {
+		t.Fatalf("could not update: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (id, name, created, admin) VALUES (5, 'eve', '2020-01-02 03:04:05', 1)"); err != nil {
+		t.Fatalf("could not insert: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES (42)"); err == nil {
+		t.Error("Expected an integer not to be stored in a string")
+	}
+
+	var names []string
+	for _, u := range users {
+		names = append(names, u.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"carol", "dave", "eve"}) {
+		t.Errorf("Expected carol, dave and eve, got %v", names)
+	}
+	if u := users[1]; u.ID != 4 || u.Email == nil || *u.Email != "dave@example.com" {
+		t.Errorf("Expected dave to be updated, got %+v", u)
+	}
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if u := users[2]; u.ID != 5 || !u.Created.Equal(created) || !u.Admin {
+		t.Errorf("Expected eve to be inserted, got %+v", u)
+	}
+
+	// Rows appended outside of SQL keep the rowids of the others.
+	users = append(users, &sliceUser{Name: "frank"})
+	var rowids []int64
+	rows, err := db.Query("SELECT rowid FROM users")
+	if err != nil {
+		t.Fatalf("could not query slice: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rowid int64
+		if err := rows.Scan(&rowid); err != nil {
+			t.Fatalf("could not scan: %v", err)
+		}
+		rowids = append(rowids, rowid)
+	}
+	if !reflect.DeepEqual(rowids, []int64{3, 4, 5, 6}) {
+		t.Errorf("Expected the rowids 3 to 6, got %v", rowids)
+	}
+}
+
+func TestSliceModuleConcurrent(t *testing.T) {
+	type item struct {
+		N     int64  `sqlite:"n"`
+		Quote string `sqlite:"say \"hi\""`
+	}
+	items := []item{{1, "a"}}
+	m, err := NewSliceModule(&items)
+	if err != nil {
+		t.Fatalf("could not create module: %v", err)
+	}
+	// The module is shared by all the connections of the pool.
+	sql.Register("sqlite3_TestSliceModuleConcurrent", &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			return conn.CreateModule("items", m)
+		},
+	})
+	db, err := sql.Open("sqlite3_TestSliceModuleConcurrent", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open db: %v", err)
+	}
+	defer db.Close()
+
+	const workers, inserts = 4, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < inserts; i++ {
+				if _, err := db.Exec(`INSERT INTO items (n, "say ""hi""") VALUES (?, 'b')`, i); err != nil {
+					errs <- err
+					return
+				}
+				var n int
+				if err := db.QueryRow(`SELECT count(*) FROM items WHERE "say ""hi""" = 'b'`).Scan(&n); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("could not use the slice: %v", err)
+	}
+	if len(items) != 1+workers*inserts {
+		t.Errorf("Expected %d items, got %d", 1+workers*inserts, len(items))
+	}
+}
+
+func TestNewSliceModuleErrors(t *testing.T) {
+	for _, data := range []interface{}{
+		nil,
+		42,
+		[]int{1},
+		(*[]sliceUser)(nil),
+		[]struct{ C chan int }{},
+		[]struct {
+			A int `sqlite:"a,unique"`
+		}{},
+		[]struct{ a int }{},
+	} {
+		if _, err := NewSliceModule(data); err == nil {
+			t.Errorf("Expected an error for %T", data)
+		}
+	}
+}
@@ -2,7 +2,6 @@
 //
 // Use of this source code is governed by an MIT-style
 // license that can be found in the LICENSE file.
-// +build vtable
 
 package sqlite3
 
@@ -158,6 +157,127 @@ func TestCreateModule(t *testing.T) {
 	}
 }
 
+func TestVTabReleasesHandles(t *testing.T) {
+	var conn *SQLiteConn
+	var vals []interface{}
+	sql.Register("sqlite3_TestVTabReleasesHandles", &SQLiteDriver{
+		ConnectHook: func(c *SQLiteConn) error {
+			conn = c
+			return c.CreateModule("argv", argvModule{&vals})
+		},
+	})
+	db, err := sql.Open("sqlite3_TestVTabReleasesHandles", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	handles := countHandles(conn)
+
+	if _, err := db.Exec("CREATE VIRTUAL TABLE t USING argv()"); err != nil {
+		t.Fatalf("could not create vtable: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		var n int
+		if err := db.QueryRow("SELECT count(*) FROM t").Scan(&n); err != nil {
+			t.Fatalf("could not query vtable: %v", err)
+		}
+	}
+	if _, err := db.Exec("DROP TABLE t"); err != nil {
+		t.Fatalf("could not drop vtable: %v", err)
+	}
+	if got := countHandles(conn); got != handles {
+		t.Errorf("Expected the table and its cursors to be released, got %d handles instead of %d", got, handles)
+	}
+}
+
+// failModule creates argv tables whose Disconnect and Destroy fail.
+type failModule struct {
+	argvModule
+}
+
+type failVTab struct {
+	*argvVTab
+}
+
+func (m failModule) Create(c *SQLiteConn, args []string) (VTab, error) {
+	vtab, err := m.argvModule.Create(c, args)
+	if err != nil {
+		return nil, err
+	}
+	return failVTab{vtab.(*argvVTab)}, nil
+}
+
+func (m failModule) Connect(c *SQLiteConn, args []string) (VTab, error) {
+	return m.Create(c, args)
+}
+
+func (v failVTab) Disconnect() error {
+	return errors.New("disconnect failed")
+}
+
+func (v failVTab) Destroy() error {
+	return errors.New("destroy failed")
+}
+
+func TestVTabReleasesHandlesOnError(t *testing.T) {
+	tempFilename := TempFilename(t)
+	defer os.Remove(tempFilename)
+	var conn *SQLiteConn
+	var vals []interface{}
+	sql.Register("sqlite3_TestVTabReleasesHandlesOnError", &SQLiteDriver{
+		ConnectHook: func(c *SQLiteConn) error {
+			conn = c
+			return c.CreateModule("fail", failModule{argvModule{&vals}})
+		},
+	})
+	db, err := sql.Open("sqlite3_TestVTabReleasesHandlesOnError", tempFilename)
+	if err != nil {
+		t.Fatalf("could not open db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	other, err := sql.Open("sqlite3", tempFilename)
+	if err != nil {
+		t.Fatalf("could not open db: %v", err)
+	}
+	defer other.Close()
+
+	if _, err := db.Exec("CREATE VIRTUAL TABLE t USING fail()"); err != nil {
+		t.Fatalf("could not create vtable: %v", err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM t").Scan(&n); err != nil {
+		t.Fatalf("could not query vtable: %v", err)
+	}
+	handles := countHandles(conn)
+
+	// A schema change on another connection makes SQLite disconnect the
+	// table and connect it again, whatever Disconnect returns.
+	for i := 0; i < 3; i++ {
+		if _, err := other.Exec(fmt.Sprintf("CREATE TABLE t%d (x)", i)); err != nil {
+			t.Fatalf("could not change schema: %v", err)
+		}
+		if err := db.QueryRow("SELECT count(*) FROM t").Scan(&n); err != nil {
+			t.Fatalf("could not query vtable: %v", err)
+		}
+	}
+	if got := countHandles(conn); got != handles {
+		t.Errorf("Expected the disconnected tables to be released, got %d handles instead of %d", got, handles)
+	}
+
+	// SQLite keeps the table when Destroy fails.
+	if _, err := db.Exec("DROP TABLE t"); err == nil {
+		t.Fatalf("Expected DROP TABLE to fail, got %v", err)
+	}
+	if err := db.QueryRow("SELECT count(*) FROM t").Scan(&n); err != nil {
+		t.Fatalf("could not query vtable: %v", err)
+	}
+}
+
 func TestVUpdate(t *testing.T) {
 	tempFilename := TempFilename(t)
 	defer os.Remove(tempFilename)
@@ -483,3 +603,678 @@ func (c *vtabUpdateCursor) Rowid() (int64, error) {
 func (c *vtabUpdateCursor) Close() error {
 	return nil
 }
+
+// panicModule creates tables that panic in the cursor method named by the
+// first argument of the table.
+type panicModule struct{}
+
+type panicVTab struct {
+	testVTab
+	method string
+}
+
+type panicVTabCursor struct {
+	testVTabCursor
+	method string
+}
+
+func (m panicModule) Create(c *SQLiteConn, args []string) (VTab, error) {
+	err := c.DeclareVTab("CREATE TABLE x(test INTEGER)")
+	if err != nil {
+		return nil, err
+	}
+	return &panicVTab{testVTab{[]int{1, 2, 3}}, args[3]}, nil
+}
+
+func (m panicModule) Connect(c *SQLiteConn, args []string) (VTab, error) {
+	return m.Create(c, args)
+}
+
+func (m panicModule) DestroyModule() {}
+
+func (v *panicVTab) Open() (VTabCursor, error) {
+	if v.method == "Open" {
+		panic("Open")
+	}
+	return &panicVTabCursor{testVTabCursor{&v.testVTab, 0}, v.method}, nil
+}
+
+func (vc *panicVTabCursor) EOF() bool {
+	if vc.method == "EOF" {
+		panic("EOF")
+	}
+	return vc.testVTabCursor.EOF()
+}
+
+func (vc *panicVTabCursor) Column(c *SQLiteContext, col int) error {
+	if vc.method == "Column" {
+		panic("Column")
+	}
+	return vc.testVTabCursor.Column(c, col)
+}
+
+func TestVTabPanic(t *testing.T) {
+	sql.Register("sqlite3_TestVTabPanic", &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			return conn.CreateModule("panic", panicModule{})
+		},
+	})
+	db, err := sql.Open("sqlite3_TestVTabPanic", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, method := range []string{"Open", "EOF", "Column"} {
+		_, err = db.Exec(fmt.Sprintf("CREATE VIRTUAL TABLE vtab_%s USING panic(%s)", method, method))
+		if err != nil {
+			t.Fatalf("could not create vtable: %v", err)
+		}
+		var sum int
+		err = db.QueryRow(fmt.Sprintf("SELECT sum(test) FROM vtab_%s", method)).Scan(&sum)
+		if err == nil || !strings.Contains(err.Error(), "panic in Go callback: "+method) {
+			t.Errorf("Expected the panic in %s to be reported, got %v", method, err)
+		}
+	}
+}
+
+// argvModule creates tables of the pairs (i/3, i%3) for i in [0, 9). They
+// receive the values of the equality constraints on b and a in this order,
+// and only check the one on a.
+type argvModule struct {
+	vals *[]interface{}
+}
+
+type argvVTab struct {
+	vals *[]interface{}
+}
+
+type argvVTabCursor struct {
+	vTab  *argvVTab
+	a     int64
+	index int
+}
+
+func (m argvModule) Create(c *SQLiteConn, args []string) (VTab, error) {
+	err := c.DeclareVTab("CREATE TABLE x(a INTEGER, b INTEGER)")
+	if err != nil {
+		return nil, err
+	}
+	return &argvVTab{m.vals}, nil
+}
+
+func (m argvModule) Connect(c *SQLiteConn, args []string) (VTab, error) {
+	return m.Create(c, args)
+}
+
+func (m argvModule) DestroyModule() {}
+
+func (v *argvVTab) BestIndex(cst []InfoConstraint, ob []InfoOrderBy) (*IndexResult, error) {
+	res := &IndexResult{
+		ArgvIndex:     make([]int, len(cst)),
+		Omit:          make([]bool, len(cst)),
+		EstimatedCost: 1000,
+	}
+	a, b := -1, -1
+	for i, c := range cst {
+		if c.Usable && c.Op == OpEQ && c.Column == 0 {
+			a = i
+		}
+		if c.Usable && c.Op == OpEQ && c.Column == 1 {
+			b = i
+		}
+	}
+	if a >= 0 && b >= 0 {
+		res.ArgvIndex[b] = 1
+		res.ArgvIndex[a] = 2
+		res.Omit[a] = true
+		res.IdxNum = 1
+		res.EstimatedCost = 1
+	}
+	return res, nil
+}
+
+func (v *argvVTab) Disconnect() error {
+	return nil
+}
+
+func (v *argvVTab) Destroy() error {
+	return nil
+}
+
+func (v *argvVTab) Open() (VTabCursor, error) {
+	return &argvVTabCursor{vTab: v}, nil
+}
+
+func (vc *argvVTabCursor) Close() error {
+	return nil
+}
+
+func (vc *argvVTabCursor) Filter(idxNum int, idxStr string, vals []interface{}) error {
+	*vc.vTab.vals = vals
+	vc.a = -1
+	if idxNum == 1 {
+		vc.a = vals[1].(int64)
+	}
+	vc.index = -1
+	return vc.Next()
+}
+
+func (vc *argvVTabCursor) Next() error {
+	vc.index++
+	for vc.a >= 0 && vc.index < 9 && int64(vc.index/3) != vc.a {
+		vc.index++
+	}
+	return nil
+}
+
+func (vc *argvVTabCursor) EOF() bool {
+	return vc.index >= 9
+}
+
+func (vc *argvVTabCursor) Column(c *SQLiteContext, col int) error {
+	if col == 0 {
+		c.ResultInt(vc.index / 3)
+	} else {
+		c.ResultInt(vc.index % 3)
+	}
+	return nil
+}
+
+func (vc *argvVTabCursor) Rowid() (int64, error) {
+	return int64(vc.index), nil
+}
+
+func TestVTabArgvIndex(t *testing.T) {
+	var vals []interface{}
+	sql.Register("sqlite3_TestVTabArgvIndex", &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			return conn.CreateModule("argv", argvModule{&vals})
+		},
+	})
+	db, err := sql.Open("sqlite3_TestVTabArgvIndex", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open db: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE VIRTUAL TABLE pairs USING argv()"); err != nil {
+		t.Fatalf("could not create vtable: %v", err)
+	}
+	rows, err := db.Query("SELECT a, b FROM pairs WHERE a = ? AND b = ?", 1, 2)
+	if err != nil {
+		t.Fatalf("could not query vtable: %v", err)
+	}
+	defer rows.Close()
+	var got [][2]int
+	for rows.Next() {
+		var a, b int
+		if err := rows.Scan(&a, &b); err != nil {
+			t.Fatalf("could not scan: %v", err)
+		}
+		got = append(got, [2]int{a, b})
+	}
+	if !reflect.DeepEqual(vals, []interface{}{int64(2), int64(1)}) {
+		t.Errorf("Expected the values of b and a in this order, got %v", vals)
+	}
+	// The cursor returns every row with a = 1: SQLite must still check b.
+	if !reflect.DeepEqual(got, [][2]int{{1, 2}}) {
+		t.Errorf("Expected the row (1, 2), got %v", got)
+	}
+}
+
+// connModule creates argv tables whose columns tell whether
+// SQLiteContext.Conn returns the connection that created them.
+type connModule struct {
+	argvModule
+}
+
+type connVTab struct {
+	*argvVTab
+	conn *SQLiteConn
+}
+
+type connVTabCursor struct {
+	*argvVTabCursor
+	conn *SQLiteConn
+}
+
+func (m connModule) Create(c *SQLiteConn, args []string) (VTab, error) {
+	vtab, err := m.argvModule.Create(c, args)
+	if err != nil {
+		return nil, err
+	}
+	return &connVTab{vtab.(*argvVTab), c}, nil
+}
+
+func (m connModule) Connect(c *SQLiteConn, args []string) (VTab, error) {
+	return m.Create(c, args)
+}
+
+func (v *connVTab) Open() (VTabCursor, error) {
+	return &connVTabCursor{&argvVTabCursor{vTab: v.argvVTab}, v.conn}, nil
+}
+
+func (vc *connVTabCursor) Column(c *SQLiteContext, col int) error {
+	c.ResultBool(c.Conn() == vc.conn)
+	return nil
+}
+
+func TestVTabContextConn(t *testing.T) {
+	var vals []interface{}
+	sql.Register("sqlite3_TestVTabContextConn", &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			return conn.CreateModule("conn", connModule{argvModule{&vals}})
+		},
+	})
+	db, err := sql.Open("sqlite3_TestVTabContextConn", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec("CREATE VIRTUAL TABLE t USING conn()"); err != nil {
+		t.Fatalf("could not create vtable: %v", err)
+	}
+	var n, ok int
+	if err := db.QueryRow("SELECT count(*), min(a) FROM t").Scan(&n, &ok); err != nil {
+		t.Fatalf("could not query vtable: %v", err)
+	}
+	if n != 9 || ok != 1 {
+		t.Errorf("Expected 9 rows with their connection, got %d rows and %d", n, ok)
+	}
+}
+
+// txModule creates updatetest tables that log the calls to their
+// transaction and savepoint methods.
+type txModule struct {
+	vtabUpdateModule
+	log *[]string
+}
+
+type txVTab struct {
+	*vtabUpdateTable
+	log *[]string
+}
+
+func (m *txModule) Create(c *SQLiteConn, args []string) (VTab, error) {
+	vtab, err := m.vtabUpdateModule.Create(c, args)
+	if err != nil {
+		return nil, err
+	}
+	return &txVTab{vtab.(*vtabUpdateTable), m.log}, nil
+}
+
+func (m *txModule) Connect(c *SQLiteConn, args []string) (VTab, error) {
+	return m.Create(c, args)
+}
+
+func (v *txVTab) logf(format string, args ...interface{}) error {
+	*v.log = append(*v.log, fmt.Sprintf(format, args...))
+	return nil
+}
+
+func (v *txVTab) Begin() error           { return v.logf("begin") }
+func (v *txVTab) Sync() error            { return v.logf("sync") }
+func (v *txVTab) Commit() error          { return v.logf("commit") }
+func (v *txVTab) Rollback() error        { return v.logf("rollback") }
+func (v *txVTab) Savepoint(n int) error  { return v.logf("savepoint %d", n) }
+func (v *txVTab) Release(n int) error    { return v.logf("release %d", n) }
+func (v *txVTab) RollbackTo(n int) error { return v.logf("rollback to %d", n) }
+
+func TestVTabTransactor(t *testing.T) {
+	var log []string
+	txMod := &txModule{vtabUpdateModule{t, make(map[string]*vtabUpdateTable)}, &log}
+	sql.Register("sqlite3_TestVTabTransactor", &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			return conn.CreateModule("txtest", txMod)
+		},
+	})
+	db, err := sql.Open("sqlite3_TestVTabTransactor", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec("CREATE VIRTUAL TABLE vt USING txtest(f1 integer)"); err != nil {
+		t.Fatalf("could not create vtable: %v", err)
+	}
+	log = nil
+	for _, q := range []string{
+		"INSERT INTO vt (f1) VALUES (1)",
+		"BEGIN",
+		"INSERT INTO vt (f1) VALUES (2)",
+		"SAVEPOINT a",
+		"INSERT INTO vt (f1) VALUES (3)",
+		"ROLLBACK TO a",
+		"RELEASE a",
+		"ROLLBACK",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("%s failed: %v", q, err)
+		}
+	}
+
+	// The insert outside of the explicit transaction runs in its own.
+	// SQLite only tells the table about the savepoint when it is written to.
+	expected := []string{
+		"begin", "sync", "commit",
+		"begin", "savepoint 0", "rollback to 0", "release 0", "rollback",
+	}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("Expected calls %q, got %q", expected, log)
+	}
+}
+
+type eponymousModule struct {
+	argvModule
+}
+
+func (m eponymousModule) EponymousOnly() bool {
+	return false
+}
+
+func TestEponymousModule(t *testing.T) {
+	var vals []interface{}
+	sql.Register("sqlite3_TestEponymousModule", &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			return conn.CreateModule("pairs", eponymousModule{argvModule{&vals}})
+		},
+	})
+	db, err := sql.Open("sqlite3_TestEponymousModule", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM pairs").Scan(&n); err != nil {
+		t.Fatalf("could not query eponymous table: %v", err)
+	}
+	if n != 9 {
+		t.Errorf("Expected 9 rows, got %d", n)
+	}
+	if _, err := db.Exec("CREATE VIRTUAL TABLE other USING pairs()"); err != nil {
+		t.Fatalf("could not create vtable: %v", err)
+	}
+	if err := db.QueryRow("SELECT count(*) FROM other WHERE a = 1").Scan(&n); err != nil {
+		t.Fatalf("could not query vtable: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Expected 3 rows, got %d", n)
+	}
+}
+
+// hooksModule creates argv tables that log their renames, overload the
+// twice and kind functions, and use the shadow tables ending in _data.
+type hooksModule struct {
+	argvModule
+	log *[]string
+}
+
+type hooksVTab struct {
+	*argvVTab
+	log *[]string
+}
+
+func (m hooksModule) Create(c *SQLiteConn, args []string) (VTab, error) {
+	vtab, err := m.argvModule.Create(c, args)
+	if err != nil {
+		return nil, err
+	}
+	return &hooksVTab{vtab.(*argvVTab), m.log}, nil
+}
+
+func (m hooksModule) Connect(c *SQLiteConn, args []string) (VTab, error) {
+	return m.Create(c, args)
+}
+
+func (m hooksModule) ShadowName(suffix string) bool {
+	*m.log = append(*m.log, "shadow "+suffix)
+	return suffix == "data"
+}
+
+func (v *hooksVTab) Rename(newName string) error {
+	if newName == "forbidden" {
+		return errors.New("forbidden name")
+	}
+	*v.log = append(*v.log, "rename "+newName)
+	return nil
+}
+
+func (v *hooksVTab) FindFunction(name string, nArg int) interface{} {
+	switch {
+	case name == "twice" && nArg == 1:
+		return func(x int64) int64 { return 2 * x }
+	case name == "triple" && nArg == 1:
+		return func(x int64) int64 { return 3 * x }
+	case name == "kind" && nArg == 1:
+		return func(ctx *SQLiteContext, args []SQLiteValue) {
+			ctx.ResultText("vtab")
+		}
+	}
+	return nil
+}
+
+func TestVTabHooks(t *testing.T) {
+	var vals []interface{}
+	var log []string
+	sql.Register("sqlite3_TestVTabHooks", &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			if err := conn.RegisterFunc("twice", func(x int64) int64 { return x }, true); err != nil {
+				return err
+			}
+			if err := conn.RegisterFunc("kind", func(x interface{}) string { return "global" }, true); err != nil {
+				return err
+			}
+			if err := conn.OverloadFunction("triple", 1); err != nil {
+				return err
+			}
+			if err := conn.CreateModule("plain", argvModule{&vals}); err != nil {
+				return err
+			}
+			return conn.CreateModule("hooks", hooksModule{argvModule{&vals}, &log})
+		},
+	})
+	db, err := sql.Open("sqlite3_TestVTabHooks", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec("CREATE VIRTUAL TABLE t USING hooks()"); err != nil {
+		t.Fatalf("could not create vtable: %v", err)
+	}
+
+	var sum int
+	if err := db.QueryRow("SELECT twice(a) FROM t WHERE a = 2").Scan(&sum); err != nil {
+		t.Fatalf("could not query vtable: %v", err)
+	}
+	if sum != 4 {
+		t.Errorf("Expected the overloaded twice to return 4, got %d", sum)
+	}
+	if err := db.QueryRow("SELECT triple(a) FROM t WHERE a = 2").Scan(&sum); err != nil {
+		t.Fatalf("could not query vtable: %v", err)
+	}
+	if sum != 6 {
+		t.Errorf("Expected the declared triple to return 6, got %d", sum)
+	}
+	if err := db.QueryRow("SELECT triple(3)").Scan(&sum); err == nil {
+		t.Error("Expected the declared triple to fail outside of the vtable")
+	}
+	if err := db.QueryRow("SELECT twice(3)").Scan(&sum); err != nil {
+		t.Fatalf("could not call twice: %v", err)
+	}
+	if sum != 3 {
+		t.Errorf("Expected the global twice outside of the vtable, got %d", sum)
+	}
+	var kind string
+	if err := db.QueryRow("SELECT kind(b) FROM t LIMIT 1").Scan(&kind); err != nil {
+		t.Fatalf("could not query vtable: %v", err)
+	}
+	if kind != "vtab" {
+		t.Errorf("Expected the overloaded raw kind, got %q", kind)
+	}
+
+	if _, err := db.Exec("ALTER TABLE t RENAME TO forbidden"); err == nil {
+		t.Fatal("Expected the rename to be refused")
+	}
+	if _, err := db.Exec("ALTER TABLE t RENAME TO u"); err != nil {
+		t.Fatalf("could not rename vtable: %v", err)
+	}
+	if err := db.QueryRow("SELECT count(*) FROM u").Scan(&sum); err != nil {
+		t.Fatalf("could not query renamed vtable: %v", err)
+	}
+	if !reflect.DeepEqual(log, []string{"rename u"}) {
+		t.Errorf("Expected only the rename to u to be logged, got %v", log)
+	}
+
+	if _, n, _ := Version(); n < 3026000 {
+		return
+	}
+	log = nil
+	// Only the module of the table is asked about its shadow tables.
+	if _, err := db.Exec("CREATE VIRTUAL TABLE v USING plain()"); err != nil {
+		t.Fatalf("could not create vtable: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE v_data(x)"); err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+	if len(log) != 0 {
+		t.Errorf("Expected the shadow names of another module not to be checked, got %v", log)
+	}
+	if _, err := db.Exec("CREATE TABLE u_data(x)"); err != nil {
+		t.Fatalf("could not create shadow table: %v", err)
+	}
+	if len(log) == 0 || log[0] != "shadow data" {
+		t.Errorf("Expected the shadow name to be checked, got %v", log)
+	}
+}
+
+// planModule creates argv tables that record the inputs of their planning
+// and ask for the IN lists of their first column all at once.
+type planModule struct {
+	argvModule
+	infos *[]IndexInfo
+}
+
+type planVTab struct {
+	*argvVTab
+	infos *[]IndexInfo
+}
+
+func (m planModule) Create(c *SQLiteConn, args []string) (VTab, error) {
+	vtab, err := m.argvModule.Create(c, args)
+	if err != nil {
+		return nil, err
+	}
+	return &planVTab{vtab.(*argvVTab), m.infos}, nil
+}
+
+func (m planModule) Connect(c *SQLiteConn, args []string) (VTab, error) {
+	return m.Create(c, args)
+}
+
+func (v *planVTab) BestIndexInfo(info *IndexInfo) (*IndexResult, error) {
+	*v.infos = append(*v.infos, *info)
+	res := &IndexResult{
+		ArgvIndex:     make([]int, len(info.Constraints)),
+		In:            make([]bool, len(info.Constraints)),
+		IdxNum:        2,
+		EstimatedCost: 1000,
+	}
+	for i, c := range info.Constraints {
+		if c.Usable && c.In && c.Column == 0 {
+			res.ArgvIndex[i] = 1
+			res.In[i] = true
+			res.EstimatedCost = 1
+			break
+		}
+	}
+	return res, nil
+}
+
+func TestVTabPlanner(t *testing.T) {
+	if _, n, _ := Version(); n < 3038000 {
+		t.Skip("the planning interfaces require SQLite 3.38.0")
+	}
+	var vals []interface{}
+	var infos []IndexInfo
+	sql.Register("sqlite3_TestVTabPlanner", &SQLiteDriver{
+		ConnectHook: func(conn *SQLiteConn) error {
+			return conn.CreateModule("plan", planModule{argvModule{&vals}, &infos})
+		},
+	})
+	db, err := sql.Open("sqlite3_TestVTabPlanner", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open db: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE VIRTUAL TABLE t USING plan()"); err != nil {
+		t.Fatalf("could not create vtable: %v", err)
+	}
+	rows, err := db.Query("SELECT DISTINCT a FROM t WHERE a IN (0, 2) AND b > 1")
+	if err != nil {
+		t.Fatalf("could not query vtable: %v", err)
+	}
+	var got []int
+	for rows.Next() {
+		var a int
+		if err := rows.Scan(&a); err != nil {
+			t.Fatalf("could not scan: %v", err)
+		}
+		got = append(got, a)
+	}
+	rows.Close()
+	if !reflect.DeepEqual(got, []int{0, 2}) {
+		t.Errorf("Expected the rows 0 and 2, got %v", got)
+	}
+	if !reflect.DeepEqual(vals, []interface{}{[]interface{}{int64(0), int64(2)}}) {
+		t.Errorf("Expected the IN list in a single value, got %v", vals)
+	}
+
+	var in, gt bool
+	for _, info := range infos {
+		if info.ColUsed != 3 {
+			t.Errorf("Expected the columns a and b to be used, got %b", info.ColUsed)
+		}
+		if info.Distinct != 2 {
+			t.Errorf("Expected a DISTINCT query, got %d", info.Distinct)
+		}
+		for _, c := range info.Constraints {
+			if c.Collation != "BINARY" {
+				t.Errorf("Expected the BINARY collation, got %q", c.Collation)
+			}
+			in = in || (c.Column == 0 && c.In)
+			gt = gt || (c.Column == 1 && c.Op == OpGT && c.HasRHS && c.RHS == int64(1))
+		}
+	}
+	if !in || !gt {
+		t.Errorf("Expected the IN constraint on a and the value of b > 1, got %+v", infos)
+	}
+
+	infos = nil
+	for _, q := range []string{
+		"SELECT a FROM t WHERE b <> 1 AND b IS NOT NULL",
+		"SELECT a FROM t WHERE b > 0 LIMIT 2",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("could not query vtable: %v", err)
+		}
+	}
+	ops := map[Op]bool{}
+	for _, info := range infos {
+		for _, c := range info.Constraints {
+			ops[c.Op] = true
+		}
+	}
+	if !ops[OpNE] || !ops[OpISNOTNULL] || !ops[OpLIMIT] {
+		t.Errorf("Expected the NE, ISNOTNULL and LIMIT constraints, got %v", ops)
+	}
+}